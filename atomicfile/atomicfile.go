@@ -0,0 +1,106 @@
+package atomicfile
+
+import (
+	"bufio"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// checkedWriter counts the bytes and the checksum of everything written
+type checkedWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	n   int64
+}
+
+func (cw *checkedWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.crc.Write(p[:n])
+	cw.n += int64(n)
+	return n, err
+}
+
+// Write writes the file through a temporary file in the same directory:
+// the content is fsynced, verified against the checksum of the written bytes,
+// and only then renamed into place, so the destination is never left half-written
+func Write(fn string, write func(w io.Writer) error) (err error) {
+	dir, base := filepath.Split(fn)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpfn := tmp.Name()
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpfn)
+		}
+	}()
+	// temporary files are private, the result gets the usual permissions
+	if err = tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	cw := &checkedWriter{w: tmp, crc: crc32.NewIEEE()}
+	buf := bufio.NewWriterSize(cw, 1024*1024)
+	if err = write(buf); err != nil {
+		return err
+	}
+	// bufio.Writer keeps the first write error, so the flush reports any failed write
+	if err = buf.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = verify(tmpfn, cw.n, cw.crc.Sum32()); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpfn, fn); err != nil {
+		return err
+	}
+	// persist the rename itself, not every platform supports syncing directories
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func verify(fn string, size int64, checksum uint32) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(crc, bufio.NewReaderSize(f, 1024*1024))
+	if err != nil {
+		return err
+	}
+	if n != size || crc.Sum32() != checksum {
+		return fmt.Errorf("verification of %s failed: %d bytes (crc %08x) on disk, %d bytes (crc %08x) written", fn, n, crc.Sum32(), size, checksum)
+	}
+	return nil
+}
+
+// CheckWritable checks that the file can be created in its directory without touching it
+func CheckWritable(fn string) error {
+	dir := filepath.Dir(fn)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fn)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/model"
+	"bitbucket.org/sitfoxfly/ranklda/parallel"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+)
+
+func save(fn string, pairs []ints.Pair, probs []float64) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		for i, p := range pairs {
+			fmt.Fprintf(f, "%d %d %f\n", p.X, p.Y, probs[i])
+		}
+		return nil
+	})
+}
+
+func main() {
+	settings := &model.InferSettings{}
+
+	flag.Int64Var(&settings.Seed, "s", 1, "random seed")
+	flag.Float64Var(&settings.InitT, "t", 1.0, "initial temperature")
+	flag.IntVar(&settings.NumSAIter, "ti", 1000, "number of iterations for SA optimization")
+	flag.Float64Var(&settings.CoolingRate, "tg", 1.0, "global cooling rate")
+	flag.IntVar(&settings.NumSamples, "samples", 50, "number of posterior samples of topic proportions after annealing")
+	flag.IntVar(&settings.SampleLag, "lag", 1, "number of sweeps between posterior samples")
+	flag.IntVar(&settings.Threads, "threads", runtime.NumCPU(), "number of inference workers")
+	var modelDataFn string
+	flag.StringVar(&modelDataFn, "data", "", "model data file (for models saved without topic counts)")
+	flag.Parse()
+
+	if flag.NArg() != 4 {
+		fmt.Println("USAGE: rldacmp <model> <data> <pairs> <output>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var m *model.Model
+	if modelDataFn == "" {
+		m = model.ReadModel(flag.Arg(0))
+	} else {
+		m = model.ReadModelWithData(flag.Arg(0), modelDataFn)
+	}
+	p, err := model.NewPredictor(m)
+	if err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+	data := model.Reduce(model.ReadData(flag.Arg(1)), m)
+	pairs := model.ReadPairs(flag.Arg(2), data.N)
+
+	// only the documents taking part in the pairs are inferred, each with its own stream
+	needed := make([]int, 0, data.N)
+	seen := make([]bool, data.N)
+	for _, p := range pairs {
+		for _, i := range []int{p.X, p.Y} {
+			if !seen[i] {
+				seen[i] = true
+				needed = append(needed, i)
+			}
+		}
+	}
+	theta := make([][]float64, data.N)
+	parallel.For(len(needed), settings.Threads, func(j int) {
+		i := needed[j]
+		_, theta[i] = p.InferDocPosterior(data.W[i], settings, umath.NewRand(settings.Seed, i))
+	})
+
+	probs := make([]float64, len(pairs))
+	for i, pair := range pairs {
+		probs[i] = p.CompareDocs(theta[pair.X], theta[pair.Y])
+	}
+
+	if err := save(flag.Arg(3), pairs, probs); err != nil {
+		log.Fatal("ERROR: unable to save probabilities: ", err)
+	}
+}
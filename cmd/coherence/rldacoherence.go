@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
+	"bitbucket.org/sitfoxfly/ranklda/model"
+	"bitbucket.org/sitfoxfly/ranklda/topics"
+	"github.com/gonum/floats"
+)
+
+func save(fn string, top [][]int, vocab []string, umass, npmi, cv []float64) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		fmt.Fprintln(f, "topic\tumass\tnpmi\tcv\twords")
+		for i := range top {
+			fmt.Fprintf(f, "%d\t%f\t%f\t%f\t%s\n", i, umass[i], npmi[i], cv[i], topics.Words(top[i], vocab))
+		}
+		k := float64(len(top))
+		_, err := fmt.Fprintf(f, "average\t%f\t%f\t%f\t\n", floats.Sum(umass)/k, floats.Sum(npmi)/k, floats.Sum(cv)/k)
+		return err
+	})
+}
+
+// readDocs reads the documents one per line as word ids in token order
+func readDocs(fn string) [][]int {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatal("ERROR: unable to open reference documents", err)
+	}
+	defer f.Close()
+	docs := make([][]int, 0, 1024)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		doc, err := model.ParseDoc(sc.Text())
+		if err != nil {
+			log.Fatalf("ERROR: unable to parse document at line %d: %v\n", line, err)
+		}
+		docs = append(docs, doc)
+	}
+	if sc.Err() != nil {
+		log.Fatal("ERROR: unable to read reference documents", sc.Err())
+	}
+	return docs
+}
+
+func main() {
+	var n int
+	var npmiWindow, cvWindow int
+	var vocabFn string
+	flag.IntVar(&n, "n", 10, "number of top words of every topic")
+	flag.IntVar(&npmiWindow, "npmiwindow", 0, "sliding window of NPMI, whole documents if not positive; a positive window reads the reference corpus as documents in token order, one per line")
+	flag.IntVar(&cvWindow, "cvwindow", 0, "sliding window of C_v, whole documents if not positive; a positive window reads the reference corpus as documents in token order, one per line")
+	flag.StringVar(&vocabFn, "vocab", "", "vocabulary file, one word per line")
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		fmt.Println("USAGE: rldacoherence <model> <reference data | reference documents with a window> <output>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if n < 2 {
+		log.Fatalf("ERROR: coherence is averaged over the pairs of top words, -n must be at least 2, got %d\n", n)
+	}
+
+	m := model.ReadModel(flag.Arg(0))
+	var vocab []string
+	if vocabFn != "" {
+		vocab = model.ReadIDs(vocabFn)
+		if len(vocab) < m.V() {
+			log.Fatalf("ERROR: %d vocabulary entries for %d words\n", len(vocab), m.V())
+		}
+	}
+	// the data file keeps bags of words, the sliding windows need the documents in token order
+	var docs [][]int
+	if npmiWindow > 0 || cvWindow > 0 {
+		docs = readDocs(flag.Arg(1))
+	} else {
+		docs = model.ReadData(flag.Arg(1)).W
+	}
+
+	top := topics.TopWords(m.LogPhi(), n)
+	umass := topics.UMass(top, docs)
+	npmi := topics.NPMI(top, docs, npmiWindow)
+	cv := topics.CV(top, docs, cvWindow)
+	k := float64(len(top))
+	log.Printf("average coherence: UMass = %f, NPMI = %f, C_v = %f\n", floats.Sum(umass)/k, floats.Sum(npmi)/k, floats.Sum(cv)/k)
+
+	if err := save(flag.Arg(2), top, vocab, umass, npmi, cv); err != nil {
+		log.Fatal("ERROR: unable to save coherence: ", err)
+	}
+}
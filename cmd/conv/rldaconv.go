@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"bitbucket.org/sitfoxfly/ranklda/model"
+)
+
+func main() {
+	var format string
+	flag.StringVar(&format, "f", "", "output format: txt or bin (default: the opposite of the input)")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Println("USAGE: rldaconv <input model> <output model>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	infn := flag.Arg(0)
+	outfn := flag.Arg(1)
+
+	if format == "" {
+		if model.IsBinary(infn) {
+			format = "txt"
+		} else {
+			format = "bin"
+		}
+	}
+
+	m := model.ReadModel(infn)
+	var err error
+	switch format {
+	case "txt":
+		err = m.Save(outfn)
+	case "bin":
+		err = m.SaveBinary(outfn)
+	default:
+		fmt.Println("Unknown format:", format)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal("ERROR: unable to save model file: ", err)
+	}
+}
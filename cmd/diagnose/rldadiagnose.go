@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
+	"bitbucket.org/sitfoxfly/ranklda/model"
+	"bitbucket.org/sitfoxfly/ranklda/topics"
+)
+
+// topic keeps the diagnostics of a single topic
+type topic struct {
+	tokens      float64
+	docs        float64
+	exclusivity float64
+	frex        float64
+	background  float64
+	nearest     int
+	flags       []string
+	words       []int
+}
+
+func save(fn string, ts []*topic, diversity float64, hellinger, js [][]float64, dups []topics.Pair, vocab []string) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		junk := 0
+		for _, t := range ts {
+			for _, flag := range t.flags {
+				if flag == "junk" {
+					junk++
+				}
+			}
+		}
+		fmt.Fprintf(f, "topics\t%d\n", len(ts))
+		fmt.Fprintf(f, "diversity\t%f\n", diversity)
+		fmt.Fprintf(f, "duplicate_pairs\t%d\n", len(dups))
+		fmt.Fprintf(f, "junk_topics\t%d\n\n", junk)
+
+		fmt.Fprintln(f, "topic\ttokens\tdocs\texclusivity\tfrex\tbackground_hellinger\tnearest\tnearest_hellinger\tnearest_js\tflags\twords")
+		for i, t := range ts {
+			fmt.Fprintf(f, "%d\t%f\t%f\t%f\t%f\t%f", i, t.tokens, t.docs, t.exclusivity, t.frex, t.background)
+			if t.nearest >= 0 {
+				fmt.Fprintf(f, "\t%d\t%f\t%f", t.nearest, hellinger[i][t.nearest], js[i][t.nearest])
+			} else {
+				fmt.Fprint(f, "\t-\t-\t-")
+			}
+			flags := "-"
+			if len(t.flags) > 0 {
+				flags = strings.Join(t.flags, ",")
+			}
+			fmt.Fprintf(f, "\t%s\t%s\n", flags, topics.Words(t.words, vocab))
+		}
+
+		fmt.Fprintln(f, "\ntopic_a\ttopic_b\thellinger\tjs")
+		for _, p := range dups {
+			fmt.Fprintf(f, "%d\t%d\t%f\t%f\n", p.A, p.B, p.Hellinger, p.JS)
+		}
+		return nil
+	})
+}
+
+func saveDistances(fn string, hellinger, js [][]float64) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		fmt.Fprintln(f, "topic_a\ttopic_b\thellinger\tjs")
+		for i := range js {
+			for j := i + 1; j < len(js); j++ {
+				fmt.Fprintf(f, "%d\t%d\t%f\t%f\n", i, j, hellinger[i][j], js[i][j])
+			}
+		}
+		return nil
+	})
+}
+
+func main() {
+	var n int
+	var frexWeight float64
+	var dupThreshold float64
+	var junkShare float64
+	var junkDist float64
+	var vocabFn string
+	var distancesFn string
+	flag.IntVar(&n, "n", 10, "number of top words of every topic")
+	flag.Float64Var(&frexWeight, "frexweight", 0.5, "weight of the exclusivity in FREX")
+	flag.Float64Var(&dupThreshold, "dup", 0.25, "Jensen-Shannon distance below which two topics are flagged as duplicates")
+	flag.Float64Var(&junkShare, "junkshare", 0.1, "token share, relative to the uniform share 1/K, below which a topic is flagged as junk")
+	flag.Float64Var(&junkDist, "junkdist", 0.2, "Hellinger distance to the corpus word distribution below which a topic is flagged as junk")
+	flag.StringVar(&vocabFn, "vocab", "", "vocabulary file, one word per line")
+	flag.StringVar(&distancesFn, "distances", "", "output file of all pairwise topic distances")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Println("USAGE: rldadiagnose <model> <output>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	m := model.ReadModel(flag.Arg(0))
+	var vocab []string
+	if vocabFn != "" {
+		vocab = model.ReadIDs(vocabFn)
+		if len(vocab) < m.V() {
+			log.Fatalf("ERROR: %d vocabulary entries for %d words\n", len(vocab), m.V())
+		}
+	}
+
+	logPhi := m.LogPhi()
+	k := m.K()
+	top := topics.TopWords(logPhi, n)
+	diversity := topics.Diversity(top)
+	frex, exclusivity := topics.FREX(logPhi, top, frexWeight)
+	hellinger, js := topics.Distances(logPhi)
+	tokens, docs := topics.Prevalence(m.Z(), k)
+	background := topics.Background(logPhi, tokens)
+	dups := topics.Duplicates(hellinger, js, dupThreshold)
+
+	ts := make([]*topic, k)
+	for i := range ts {
+		t := &topic{tokens: tokens[i], docs: docs[i], exclusivity: exclusivity[i], frex: frex[i], nearest: -1, words: top[i]}
+		phi := make([]float64, len(logPhi[i]))
+		for w, x := range logPhi[i] {
+			phi[w] = math.Exp(x)
+		}
+		t.background = topics.Hellinger(phi, background)
+		for j := 0; j < k; j++ {
+			if j != i && (t.nearest < 0 || js[i][j] < js[i][t.nearest]) {
+				t.nearest = j
+			}
+		}
+		if t.tokens < junkShare/float64(k) || t.background < junkDist {
+			t.flags = append(t.flags, "junk")
+		}
+		if t.nearest >= 0 && js[i][t.nearest] < dupThreshold {
+			t.flags = append(t.flags, "duplicate")
+		}
+		ts[i] = t
+	}
+	log.Printf("diversity = %f, %d duplicate pairs\n", diversity, len(dups))
+
+	if err := save(flag.Arg(1), ts, diversity, hellinger, js, dups, vocab); err != nil {
+		log.Fatal("ERROR: unable to save report: ", err)
+	}
+	if distancesFn != "" {
+		if err := saveDistances(distancesFn, hellinger, js); err != nil {
+			log.Fatal("ERROR: unable to save distances: ", err)
+		}
+	}
+}
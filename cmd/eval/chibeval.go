@@ -1,273 +1,179 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math"
-	"math/rand"
 	"os"
-	"strconv"
-	"strings"
+	"runtime"
 
-	"github.com/gonum/floats"
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
+	"bitbucket.org/sitfoxfly/ranklda/estimate"
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/model"
 )
 
-type lda struct {
-	alpha float64
-	phi   [][]float64
-}
-
-func parseFloats(line string, isLog bool) []float64 {
-	sc := bufio.NewScanner(strings.NewReader(line))
-	parse := make([]float64, 0, 64)
-	sc.Split(bufio.ScanWords)
-	for sc.Scan() {
-		if val, err := strconv.ParseFloat(sc.Text(), 64); err == nil {
-			if isLog {
-				val = math.Exp(val)
-			}
-			parse = append(parse, val)
-		} else {
-			log.Panic("ERROR: unable to parse floats", err)
+// lowEffectiveSamples is the share of the samples below which the effective sample size
+// of the joint comparison likelihood is reported as too low
+const lowEffectiveSamples = 0.1
+
+// summary aggregates the per-document log-likelihoods in nats
+type summary struct {
+	docs       int
+	words      int
+	total      float64
+	mean       float64
+	stdErr     float64
+	perWord    float64
+	perplexity float64
+}
+
+func summarize(logLik []float64, docs [][]int) *summary {
+	s := &summary{docs: len(docs)}
+	for i, l := range logLik {
+		s.total += l
+		s.words += len(docs[i])
+	}
+	s.mean = s.total / float64(s.docs)
+	if s.docs > 1 {
+		variance := 0.0
+		for _, l := range logLik {
+			variance += (l - s.mean) * (l - s.mean)
 		}
+		variance /= float64(s.docs - 1)
+		s.stdErr = math.Sqrt(variance / float64(s.docs))
 	}
-	if isLog {
-		floats.Scale(1/floats.Sum(parse), parse)
-	}
-	return parse
-}
-
-func readPhi(fn string, isLog bool) [][]float64 {
-	f, err := os.Open(fn)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-
-	phi := make([][]float64, 0, 10)
-	sc := bufio.NewScanner(f)
-	buf := make([]byte, 0, 64*1024)
-	sc.Buffer(buf, 1024*1024)
-	for sc.Scan() {
-		phi = append(phi, parseFloats(sc.Text(), isLog))
-	}
-	if sc.Err() != nil {
-		log.Panic("ERROR: unable to parse data file", sc.Err().Error())
-	}
-	return phi
+	s.perWord = s.total / float64(s.words)
+	s.perplexity = math.Exp(-s.perWord)
+	return s
 }
 
-func parseAndFilterInts(s string, v int) []int {
-	sc := bufio.NewScanner(strings.NewReader(s))
-	sc.Split(bufio.ScanWords)
-	result := make([]int, 0, 10)
-	for sc.Scan() {
-		if val, err := strconv.Atoi(sc.Text()); err == nil {
-			if val < v {
-				result = append(result, val)
-			}
-		} else {
-			log.Panic("ERROR: unable to parse data file", err)
+func saveDocs(fn string, logLik []float64, docs [][]int) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		fmt.Fprintln(f, "doc\tnats\tbits\tlength\tnats_per_word")
+		for i, l := range logLik {
+			fmt.Fprintf(f, "%d\t%f\t%f\t%d\t%f\n", i, l, l/math.Ln2, len(docs[i]), l/float64(len(docs[i])))
 		}
-	}
-	return result
-}
-
-func readDocs(fn string, v int) [][]int {
-	f, err := os.Open(fn)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-
-	docs := make([][]int, 0, 10)
-
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		docs = append(docs, parseAndFilterInts(sc.Text(), v))
-	}
-
-	return docs
-}
-
-func sample(p []float64) int {
-	x := rand.Float64()
-	cda := 0.0
-	for i, pi := range p {
-		cda += pi
-		if x < cda {
-			return i
-		}
-	}
-	return len(p) - 1
-}
-
-func sampleGibbsForward(model *lda, doc []int, z []int, zn []int) {
-	n := len(doc)
-	k := len(zn)
-	p := make([]float64, k)
-	for i := 0; i < n; i++ {
-		zn[z[i]]--
-		for j := 0; j < k; j++ {
-			p[j] = model.phi[j][doc[i]] * (float64(zn[j]) + model.alpha)
-		}
-		floats.Scale(1/floats.Sum(p), p)
-		newZ := sample(p)
-		zn[newZ]++
-		z[i] = newZ
-	}
-}
-
-func sampleGibbsBackward(model *lda, doc []int, z []int, zn []int) {
-	n := len(doc)
-	k := len(zn)
-	p := make([]float64, k)
-	for i := n - 1; i > -1; i-- {
-		zn[z[i]]--
-		for j := 0; j < k; j++ {
-			p[j] = model.phi[j][doc[i]] * (float64(zn[j]) + model.alpha)
-		}
-		floats.Scale(1/floats.Sum(p), p)
-		newZ := sample(p)
-		zn[newZ]++
-		z[i] = newZ
-	}
-}
-
-func evalPwz(model *lda, doc []int, z []int) float64 {
-	val := 0.0
-	for i, w := range doc {
-		val += math.Log2(model.phi[z[i]][w])
-	}
-	return val
-}
-
-func lgamma(x float64) float64 {
-	y, _ := math.Lgamma(x)
-	return y
-}
-
-func evalPz(model *lda, doc []int, zn []int) float64 {
-	k := float64(len(zn))
-	val := lgamma(model.alpha*k) - lgamma(model.alpha*k+float64(len(doc)))
-	for _, zni := range zn {
-		val += lgamma(float64(zni)+model.alpha) - lgamma(model.alpha)
-	}
-	return val / math.Log(2.0)
+		return nil
+	})
 }
 
-func evalTz(model *lda, doc []int, za, zb []int, zn []int) float64 {
-	k := len(zn)
-	n := len(doc)
-	curZn := make([]int, k)
-	copy(curZn, zn)
-
-	val := 0.0
-	p := make([]float64, k)
-	for i := n - 1; i > -1; i-- {
-		curZn[za[i]]--
-		for j := 0; j < k; j++ {
-			p[j] = model.phi[j][doc[i]] * (float64(curZn[j]) + model.alpha)
+func saveComparisons(fn string, comps []ints.Pair, cl *estimate.ComparisonLikelihood) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		fmt.Fprintln(f, "winner\tloser\tnats\tprob")
+		for i, c := range comps {
+			fmt.Fprintf(f, "%d\t%d\t%f\t%f\n", c.X, c.Y, cl.PerComparison[i], math.Exp(cl.PerComparison[i]))
 		}
-		floats.Scale(1/floats.Sum(p), p)
-		val += math.Log(p[zb[i]])
-		curZn[zb[i]]++
-	}
-	return val
-}
-
-func evalOne(model *lda, doc []int, numSamples int) float64 {
-	burnIn := 1000
-	k := len(model.phi)
-	n := len(doc)
-	z := make([]int, n)
-	zn := make([]int, k)
-
-	for i := 0; i < n; i++ {
-		z[i] = rand.Intn(k)
-		zn[z[i]]++
-	}
-
-	// burn-in first to get a good sample
-	for a := 0; a < burnIn; a++ {
-		sampleGibbsForward(model, doc, z, zn)
-	}
-
-	samples := make([][]int, 0, numSamples)
-	znIndex := make([][]int, 0, numSamples)
-	for i := 0; i < numSamples; i++ {
-		samples = append(samples, make([]int, n))
-		znIndex = append(znIndex, make([]int, k))
-	}
-	x := rand.Intn(numSamples)
-	copy(samples[x], z)
-	copy(znIndex[x], zn)
-	sampleGibbsBackward(model, doc, samples[x], znIndex[x])
-	for i := x + 1; i < numSamples; i++ {
-		copy(samples[i], samples[i-1])
-		copy(znIndex[i], znIndex[i-1])
-		sampleGibbsForward(model, doc, samples[i], znIndex[i])
-	}
-
-	for i := x - 1; i > -1; i-- {
-		copy(samples[i], samples[i+1])
-		copy(znIndex[i], znIndex[i+1])
-		sampleGibbsBackward(model, doc, samples[i], znIndex[i])
-	}
-
-	tzs := make([]float64, 0, numSamples)
-	for _, sample := range samples {
-		tzs = append(tzs, evalTz(model, doc, z, sample, zn))
-	}
-
-	return evalPwz(model, doc, z) + evalPz(model, doc, zn) + math.Log2(float64(numSamples)) - floats.LogSumExp(tzs)/math.Log(2.0)
-}
-
-func eval(model *lda, docs [][]int, numSamples int) float64 {
-	sum := 0.0
-	for i, doc := range docs {
-		eval := evalOne(model, doc, numSamples)
-		log.Printf("chibeval(docs[%d]) = %f\n", i, eval)
-		sum += eval
-	}
-	return sum
-}
-
-func smooth(phis [][]float64, smoothing float64) {
-	for _, phi := range phis {
-		floats.AddConst(smoothing, phi)
-		floats.Scale(1.0/floats.Sum(phi), phi)
-	}
+		return nil
+	})
 }
 
 func main() {
-	var seed int64
-	var numSamples int
+	s := &estimate.Settings{}
 	var phiFn string
 	var alpha float64
 	var dataFn string
+	var corpusFn string
+	var modelFn string
+	var outFn string
 	var isLog bool
 	var smoothing float64
+	var method string
+	var joint bool
+	var nuFn string
+	var compOutFn string
 
-	flag.Int64Var(&seed, "seed", 1, "random seed")
+	flag.Int64Var(&s.Seed, "seed", 1, "random seed")
 	flag.Float64Var(&alpha, "alpha", 1.0, "alpha")
 	flag.StringVar(&phiFn, "phi", "", "phi definition")
+	flag.StringVar(&modelFn, "model", "", "CompareLDA model, its phi and beta are used instead of -phi and -alpha")
 	flag.StringVar(&dataFn, "data", "", "held-out data file")
-	flag.IntVar(&numSamples, "samples", 100, "num of samples")
+	flag.StringVar(&corpusFn, "corpus", "", "held-out data file in the standard corpus format")
+	flag.StringVar(&method, "method", "chib", "estimator: chib, ais (annealed importance sampling) or hm (harmonic mean)")
+	flag.IntVar(&s.NumSamples, "samples", 100, "num of samples (AIS runs for ais)")
+	flag.IntVar(&s.BurnIn, "burnin", 1000, "number of burn-in sweeps")
+	flag.IntVar(&s.NumTemps, "temps", 1000, "number of temperatures of every AIS run")
+	flag.IntVar(&s.Threads, "threads", runtime.NumCPU(), "number of workers")
+	flag.StringVar(&outFn, "out", "", "output file of the per-document log-likelihoods")
+	flag.BoolVar(&joint, "joint", false, "estimate the likelihood of the comparisons of the corpus and the joint likelihood of words and comparisons")
+	flag.StringVar(&nuFn, "nu", "", "topic weights of the comparisons for -phi, e.g. Bradley-Terry weights over LDA topics")
+	flag.StringVar(&compOutFn, "compout", "", "output file of the per-comparison log-likelihoods")
 	flag.BoolVar(&isLog, "log", false, "exp transformation required")
 	flag.Float64Var(&smoothing, "smoothing", 0, "smoothing param")
 
 	flag.Parse()
 
-	rand.Seed(seed)
+	estimators := map[string]func(*estimate.LDA, [][]int, *estimate.Settings) []float64{
+		"chib": estimate.Chib,
+		"ais":  estimate.AIS,
+		"hm":   estimate.HarmonicMean,
+	}
+	estimator, ok := estimators[method]
+	if !ok || (phiFn == "") == (modelFn == "") || (dataFn == "") == (corpusFn == "") ||
+		joint && (corpusFn == "" || phiFn != "" && nuFn == "") {
+		fmt.Println("USAGE: chibeval [-method chib|ais|hm] (-phi <phi> [-nu <nu>] | -model <model>) (-data <docs> | -corpus <data>) [-joint]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if joint && s.NumSamples < 1 {
+		log.Fatalf("ERROR: the comparison likelihood requires at least one posterior sample, got -samples %d\n", s.NumSamples)
+	}
+
+	var lda *estimate.LDA
+	if modelFn != "" {
+		lda = estimate.FromModel(model.ReadModel(modelFn))
+	} else {
+		phis := estimate.ReadPhi(phiFn, isLog)
+		lda = &estimate.LDA{Beta: estimate.Symmetric(alpha, len(phis)), Phi: phis}
+		if nuFn != "" {
+			lda.Nu = estimate.ReadNu(nuFn)
+			if len(lda.Nu) != lda.K() {
+				log.Fatalf("ERROR: %d topic weights for %d topics\n", len(lda.Nu), lda.K())
+			}
+		}
+	}
+	estimate.Smooth(lda.Phi, smoothing)
+	var docs [][]int
+	var comps []ints.Pair
+	if corpusFn != "" {
+		docs, comps = estimate.ReadCorpus(corpusFn, lda.V())
+		for i, c := range comps {
+			if c.X < 0 || c.X >= len(docs) || c.Y < 0 || c.Y >= len(docs) {
+				log.Fatalf("ERROR: comparison %d refers to a missing document\n", i+1)
+			}
+		}
+	} else {
+		docs = estimate.ReadDocs(dataFn, lda.V())
+	}
+
+	logLik := estimator(lda, docs, s)
+	if outFn != "" {
+		if err := saveDocs(outFn, logLik, docs); err != nil {
+			log.Fatal("ERROR: unable to save per-document log-likelihoods: ", err)
+		}
+	}
 
-	phis := readPhi(phiFn, isLog)
-	smooth(phis, smoothing)
-	model := &lda{alpha, phis}
-	docs := readDocs(dataFn, len(model.phi[0]))
-	eval := eval(model, docs, numSamples)
-	log.Printf("chibeval(docs[0:%d]) = %.2f\n", len(docs), eval)
-	fmt.Printf("%.2f\n", eval)
+	sum := summarize(logLik, docs)
+	log.Printf("%s(docs[0:%d]) = %f nats (%f bits), %d words\n", method, sum.docs, sum.total, sum.total/math.Ln2, sum.words)
+	log.Printf("per document = %f ± %f nats, per word = %f nats, perplexity = %f\n", sum.mean, sum.stdErr, sum.perWord, sum.perplexity)
+	if joint {
+		cl := estimate.Comparisons(lda, docs, comps, s)
+		if compOutFn != "" {
+			if err := saveComparisons(compOutFn, comps, cl); err != nil {
+				log.Fatal("ERROR: unable to save per-comparison log-likelihoods: ", err)
+			}
+		}
+		log.Printf("comparisons = %f nats (%f bits), %d comparisons, per comparison = %f nats\n",
+			cl.LogLik, cl.LogLik/math.Ln2, len(comps), cl.LogLik/float64(len(comps)))
+		log.Printf("effective sample size of the comparisons = %.1f of %d samples\n", cl.EffectiveSamples, s.NumSamples)
+		if cl.EffectiveSamples < lowEffectiveSamples*float64(s.NumSamples) {
+			log.Println("WARNING: a few samples dominate the joint likelihood of the comparisons, the estimate has a high variance; use more -samples or compare the per-comparison likelihoods")
+		}
+		log.Printf("joint words and comparisons = %f nats (%f bits)\n", sum.total+cl.LogLik, (sum.total+cl.LogLik)/math.Ln2)
+	}
+	// the total is printed in bits
+	fmt.Printf("%.2f\n", sum.total/math.Ln2)
 }
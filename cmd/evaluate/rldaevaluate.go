@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/model"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+)
+
+// epsilon bounds the predicted probabilities away from 0 and 1, so that the log-loss stays finite
+const epsilon = 1e-15
+
+// group is a set of test documents evaluated together, e.g. the documents of a query,
+// with the comparisons among them
+type group struct {
+	name  string
+	docs  []int
+	comps []ints.Pair
+}
+
+// metrics keeps the evaluation of a group, NaN where the metric is not applicable
+type metrics struct {
+	docs        int
+	comparisons int
+	accuracy    float64
+	logLoss     float64
+	auc         float64
+	kendall     float64
+	spearman    float64
+	ndcg        float64
+}
+
+func (r *metrics) values() []float64 {
+	return []float64{r.accuracy, r.logLoss, r.auc, r.kendall, r.spearman, r.ndcg}
+}
+
+// readGold reads the gold relevance of every document, one value per line, higher is better
+func readGold(fn string, n int) []float64 {
+	ids := model.ReadIDs(fn)
+	if len(ids) != n {
+		log.Fatalf("ERROR: %d gold values for %d documents\n", len(ids), n)
+	}
+	gold := make([]float64, n)
+	for i, s := range ids {
+		var err error
+		if gold[i], err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+			log.Fatalf("ERROR: unable to parse gold value at line %d: %v\n", i+1, err)
+		}
+	}
+	return gold
+}
+
+// groups splits the documents and the comparisons by query in the order of the first appearance,
+// the comparisons across queries take part only in the evaluation of the whole set
+func groups(queries []string, comps []ints.Pair) []*group {
+	byName := make(map[string]*group)
+	result := make([]*group, 0)
+	for i, q := range queries {
+		g, ok := byName[q]
+		if !ok {
+			g = &group{name: q}
+			byName[q] = g
+			result = append(result, g)
+		}
+		g.docs = append(g.docs, i)
+	}
+	for _, c := range comps {
+		if queries[c.X] == queries[c.Y] {
+			g := byName[queries[c.X]]
+			g.comps = append(g.comps, c)
+		}
+	}
+	return result
+}
+
+func evaluate(p *model.Predictor, g *group, theta [][]float64, scores, gold []float64, k int) *metrics {
+	nan := math.NaN()
+	r := &metrics{docs: len(g.docs), comparisons: len(g.comps), accuracy: nan, logLoss: nan, auc: nan, kendall: nan, spearman: nan, ndcg: nan}
+
+	if len(g.comps) > 0 {
+		correct, logLoss := 0.0, 0.0
+		// every comparison is a positive score difference of the winner and its negation a negative one
+		pos := make([]float64, len(g.comps))
+		neg := make([]float64, len(g.comps))
+		for i, c := range g.comps {
+			prob := p.CompareDocs(theta[c.X], theta[c.Y])
+			if prob > 0.5 {
+				correct++
+			} else if prob == 0.5 {
+				correct += 0.5
+			}
+			logLoss -= math.Log(math.Min(math.Max(prob, epsilon), 1-epsilon))
+			pos[i] = scores[c.X] - scores[c.Y]
+			neg[i] = -pos[i]
+		}
+		r.accuracy = correct / float64(len(g.comps))
+		r.logLoss = logLoss / float64(len(g.comps))
+		r.auc = umath.AUC(pos, neg)
+	}
+
+	if gold != nil && len(g.docs) > 1 {
+		s := make([]float64, len(g.docs))
+		rel := make([]float64, len(g.docs))
+		for i, doc := range g.docs {
+			s[i] = scores[doc]
+			rel[i] = gold[doc]
+		}
+		r.kendall = umath.KendallTau(s, rel)
+		r.spearman = umath.Spearman(s, rel)
+		r.ndcg = umath.NDCG(s, rel, k)
+	}
+	return r
+}
+
+// mean averages the metrics over the groups skipping the groups where they are not applicable
+func mean(rs []*metrics) *metrics {
+	sums := make([]float64, 6)
+	counts := make([]int, 6)
+	result := &metrics{}
+	for _, r := range rs {
+		result.docs += r.docs
+		result.comparisons += r.comparisons
+		for i, x := range r.values() {
+			if !math.IsNaN(x) {
+				sums[i] += x
+				counts[i]++
+			}
+		}
+	}
+	for i := range sums {
+		sums[i] /= float64(counts[i])
+	}
+	result.accuracy, result.logLoss, result.auc = sums[0], sums[1], sums[2]
+	result.kendall, result.spearman, result.ndcg = sums[3], sums[4], sums[5]
+	return result
+}
+
+func save(fn string, names []string, rs []*metrics, k int) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		fmt.Fprintf(f, "query\tdocs\tcomparisons\taccuracy\tlog_loss\tauc\tkendall_tau\tspearman\tndcg@%d\n", k)
+		for i, r := range rs {
+			fmt.Fprintf(f, "%s\t%d\t%d", names[i], r.docs, r.comparisons)
+			for _, x := range r.values() {
+				fmt.Fprintf(f, "\t%f", x)
+			}
+			fmt.Fprintln(f)
+		}
+		return nil
+	})
+}
+
+func main() {
+	settings := &model.InferSettings{}
+
+	flag.Int64Var(&settings.Seed, "s", 1, "random seed")
+	flag.Float64Var(&settings.InitT, "t", 1.0, "initial temperature")
+	flag.IntVar(&settings.NumSAIter, "ti", 1000, "number of iterations for SA optimization")
+	flag.Float64Var(&settings.CoolingRate, "tg", 1.0, "global cooling rate")
+	flag.IntVar(&settings.NumSamples, "samples", 50, "number of posterior samples of topic proportions after annealing")
+	flag.IntVar(&settings.SampleLag, "lag", 1, "number of sweeps between posterior samples")
+	flag.IntVar(&settings.Threads, "threads", runtime.NumCPU(), "number of inference workers")
+	var modelDataFn string
+	flag.StringVar(&modelDataFn, "data", "", "model data file (for models saved without topic counts)")
+	var fast bool
+	flag.BoolVar(&fast, "fast", false, "use the fast scorer instead of annealing")
+	var goldFn, queriesFn string
+	var k int
+	flag.StringVar(&goldFn, "gold", "", "gold ranking as graded relevance of every document, one value per line, higher is better")
+	flag.StringVar(&queriesFn, "queries", "", "query of every document, one ID per line, for per-query breakdowns")
+	flag.IntVar(&k, "k", 10, "cutoff of NDCG")
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		fmt.Println("USAGE: rldaevaluate <model> <data> <output>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var m *model.Model
+	if modelDataFn == "" {
+		m = model.ReadModel(flag.Arg(0))
+	} else {
+		m = model.ReadModelWithData(flag.Arg(0), modelDataFn)
+	}
+	p, err := model.NewPredictor(m)
+	if err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+	data := model.Reduce(model.ReadData(flag.Arg(1)), m)
+	for i, c := range data.C {
+		if c.X < 0 || c.X >= data.N || c.Y < 0 || c.Y >= data.N {
+			log.Fatalf("ERROR: comparison %d refers to a missing document\n", i+1)
+		}
+	}
+	var gold []float64
+	if goldFn != "" {
+		gold = readGold(goldFn, data.N)
+	}
+
+	// the test comparisons are held out, the documents are inferred from their words only
+	var theta [][]float64
+	if fast {
+		theta = p.FastTheta(data)
+	} else {
+		_, theta = p.InferPosterior(data, settings)
+	}
+	scores := p.Score(theta)
+
+	all := &group{name: "all", docs: make([]int, data.N), comps: data.C}
+	for i := range all.docs {
+		all.docs[i] = i
+	}
+	names := make([]string, 0)
+	rs := make([]*metrics, 0)
+	if queriesFn != "" {
+		queries := model.ReadIDs(queriesFn)
+		if len(queries) != data.N {
+			log.Fatalf("ERROR: %d queries for %d documents\n", len(queries), data.N)
+		}
+		for _, g := range groups(queries, data.C) {
+			names = append(names, g.name)
+			rs = append(rs, evaluate(p, g, theta, scores, gold, k))
+		}
+		// the macro average over the queries
+		names = append(names, "mean")
+		rs = append(rs, mean(rs))
+	}
+	total := evaluate(p, all, theta, scores, gold, k)
+	names = append(names, "all")
+	rs = append(rs, total)
+	log.Printf("accuracy = %f, log-loss = %f, AUC = %f, kendall tau = %f, spearman = %f, NDCG@%d = %f\n",
+		total.accuracy, total.logLoss, total.auc, total.kendall, total.spearman, k, total.ndcg)
+
+	if err := save(flag.Arg(2), names, rs, k); err != nil {
+		log.Fatal("ERROR: unable to save evaluation: ", err)
+	}
+}
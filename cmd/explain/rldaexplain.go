@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/model"
+	"bitbucket.org/sitfoxfly/ranklda/parallel"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+)
+
+// record is the explanation of a single document (A only) or of a pair
+type record struct {
+	A int  `json:"a"`
+	B *int `json:"b,omitempty"`
+	*model.Explanation
+	Text map[int]string `json:"text,omitempty"`
+}
+
+// text maps the words of the explanation to the vocabulary
+func text(e *model.Explanation, vocab []string) map[int]string {
+	if vocab == nil {
+		return nil
+	}
+	result := make(map[int]string)
+	for _, w := range e.Words {
+		result[w.Word] = vocab[w.Word]
+	}
+	return result
+}
+
+func save(fn string, rs []*record) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		enc := json.NewEncoder(f)
+		for _, r := range rs {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func main() {
+	settings := &model.InferSettings{}
+
+	flag.Int64Var(&settings.Seed, "s", 1, "random seed")
+	flag.Float64Var(&settings.InitT, "t", 1.0, "initial temperature")
+	flag.IntVar(&settings.NumSAIter, "ti", 1000, "number of iterations for SA optimization")
+	flag.Float64Var(&settings.CoolingRate, "tg", 1.0, "global cooling rate")
+	flag.IntVar(&settings.NumSamples, "samples", 50, "number of posterior samples of topic proportions after annealing")
+	flag.IntVar(&settings.SampleLag, "lag", 1, "number of sweeps between posterior samples")
+	flag.IntVar(&settings.Threads, "threads", runtime.NumCPU(), "number of inference workers")
+	var modelDataFn string
+	var pairsFn string
+	var vocabFn string
+	var top int
+	flag.StringVar(&modelDataFn, "data", "", "model data file (for models saved without topic counts)")
+	flag.StringVar(&pairsFn, "pairs", "", "pairs to explain, one \"<a> <b>\" per line, the documents are explained if not given")
+	flag.StringVar(&vocabFn, "vocab", "", "vocabulary file, one word per line")
+	flag.IntVar(&top, "top", 5, "number of the top positive and of the top negative drivers")
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		fmt.Println("USAGE: rldaexplain [-pairs <pairs>] <model> <data> <output>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var m *model.Model
+	if modelDataFn == "" {
+		m = model.ReadModel(flag.Arg(0))
+	} else {
+		m = model.ReadModelWithData(flag.Arg(0), modelDataFn)
+	}
+	p, err := model.NewPredictor(m)
+	if err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+	data := model.Reduce(model.ReadData(flag.Arg(1)), m)
+	var vocab []string
+	if vocabFn != "" {
+		vocab = model.ReadIDs(vocabFn)
+		if len(vocab) < m.V() {
+			log.Fatalf("ERROR: %d vocabulary entries for %d words\n", len(vocab), m.V())
+		}
+	}
+	var pairs []ints.Pair
+	needed := make([]bool, data.N)
+	if pairsFn != "" {
+		pairs = model.ReadPairs(pairsFn, data.N)
+		for _, pair := range pairs {
+			needed[pair.X] = true
+			needed[pair.Y] = true
+		}
+	} else {
+		for i := range needed {
+			needed[i] = true
+		}
+	}
+
+	// every document keeps its own stream, so the explanations agree with rldainf and rldacmp
+	posts := make([]*model.Posterior, data.N)
+	parallel.For(data.N, settings.Threads, func(i int) {
+		if needed[i] {
+			posts[i] = p.InferDocExplained(data.W[i], settings, umath.NewRand(settings.Seed, i))
+		}
+	})
+
+	var rs []*record
+	if pairs != nil {
+		rs = make([]*record, len(pairs))
+		for i, pair := range pairs {
+			b := pair.Y
+			e := p.ExplainPair(posts[pair.X], posts[pair.Y]).Top(top)
+			rs[i] = &record{A: pair.X, B: &b, Explanation: e, Text: text(e, vocab)}
+		}
+	} else {
+		rs = make([]*record, data.N)
+		for i := range rs {
+			e := p.ExplainDoc(posts[i]).Top(top)
+			rs[i] = &record{A: i, Explanation: e, Text: text(e, vocab)}
+		}
+	}
+
+	if err := save(flag.Arg(2), rs); err != nil {
+		log.Fatal("ERROR: unable to save explanations: ", err)
+	}
+}
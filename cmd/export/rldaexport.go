@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
+	"bitbucket.org/sitfoxfly/ranklda/model"
+	"bitbucket.org/sitfoxfly/ranklda/npy"
+)
+
+type export struct {
+	K          int         `json:"k"`
+	V          int         `json:"v"`
+	Alpha      float64     `json:"alpha"`
+	Beta       []float64   `json:"beta"`
+	Nu         []float64   `json:"nu"`
+	Phi        [][]float64 `json:"phi"`
+	Theta      [][]float64 `json:"theta"`
+	Z          [][]int     `json:"z,omitempty"`
+	Vocabulary []string    `json:"vocabulary,omitempty"`
+	IDs        []string    `json:"ids,omitempty"`
+}
+
+func flatten(x [][]float64) []float64 {
+	result := make([]float64, 0, len(x)*len(x[0]))
+	for _, row := range x {
+		result = append(result, row...)
+	}
+	return result
+}
+
+// array is a named NPY array writer
+type array struct {
+	name  string
+	write func(w io.Writer) error
+}
+
+func arrays(e *export) []array {
+	result := []array{
+		{"alpha", func(w io.Writer) error { return npy.WriteFloat64s(w, []int{}, []float64{e.Alpha}) }},
+		{"beta", func(w io.Writer) error { return npy.WriteFloat64s(w, []int{e.K}, e.Beta) }},
+		{"nu", func(w io.Writer) error { return npy.WriteFloat64s(w, []int{e.K}, e.Nu) }},
+		{"phi", func(w io.Writer) error { return npy.WriteFloat64s(w, []int{e.K, e.V}, flatten(e.Phi)) }},
+		{"theta", func(w io.Writer) error {
+			if len(e.Theta) == 0 {
+				return npy.WriteFloat64s(w, []int{0, e.K}, nil)
+			}
+			return npy.WriteFloat64s(w, []int{len(e.Theta), e.K}, flatten(e.Theta))
+		}},
+	}
+	if e.Z != nil {
+		// the documents differ in length, so z is stored flat with the document offsets,
+		// the topics fit int32 while the offsets may exceed it on large corpora
+		offsets := make([]int64, 0, len(e.Z)+1)
+		flat := make([]int32, 0)
+		offsets = append(offsets, 0)
+		for _, zi := range e.Z {
+			for _, zij := range zi {
+				flat = append(flat, int32(zij))
+			}
+			offsets = append(offsets, int64(len(flat)))
+		}
+		result = append(result,
+			array{"z", func(w io.Writer) error { return npy.WriteInt32s(w, []int{len(flat)}, flat) }},
+			array{"z_offsets", func(w io.Writer) error { return npy.WriteInt64s(w, []int{len(offsets)}, offsets) }})
+	}
+	if e.Vocabulary != nil {
+		result = append(result, array{"vocabulary", func(w io.Writer) error { return npy.WriteStrings(w, e.Vocabulary) }})
+	}
+	if e.IDs != nil {
+		result = append(result, array{"ids", func(w io.Writer) error { return npy.WriteStrings(w, e.IDs) }})
+	}
+	return result
+}
+
+func saveJSON(fn string, e *export) error {
+	return atomicfile.Write(fn, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(e)
+	})
+}
+
+func saveNPZ(fn string, e *export) error {
+	return atomicfile.Write(fn, func(w io.Writer) error {
+		archive := npy.NewZip(w)
+		for _, a := range arrays(e) {
+			entry, err := archive.Create(a.name)
+			if err != nil {
+				return err
+			}
+			if err := a.write(entry); err != nil {
+				return err
+			}
+		}
+		return archive.Close()
+	})
+}
+
+func saveNPY(dir string, e *export) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	for _, a := range arrays(e) {
+		if err := atomicfile.Write(path.Join(dir, a.name+".npy"), a.write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	var format string
+	var vocabFn string
+	var idsFn string
+	var withZ bool
+
+	flag.StringVar(&format, "f", "json", "output format: json, npz or npy (a directory of .npy files)")
+	flag.StringVar(&vocabFn, "vocab", "", "vocabulary file, one word per line")
+	flag.StringVar(&idsFn, "ids", "", "document IDs file, one ID per line")
+	flag.BoolVar(&withZ, "z", false, "export topic assignments")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Println("USAGE: rldaexport <model> <output>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	m := model.ReadModel(flag.Arg(0))
+	outfn := flag.Arg(1)
+
+	e := &export{K: m.K(), V: m.V(), Alpha: m.Alpha(), Beta: m.Beta(), Nu: m.Nu()}
+	e.Phi = make([][]float64, m.K())
+	for i, row := range m.LogPhi() {
+		e.Phi[i] = make([]float64, len(row))
+		for j, logPhi := range row {
+			e.Phi[i][j] = math.Exp(logPhi)
+		}
+	}
+	e.Theta = m.Theta(m.Z())
+	if withZ {
+		e.Z = m.Z()
+	}
+	if vocabFn != "" {
+		e.Vocabulary = model.ReadIDs(vocabFn)
+		if len(e.Vocabulary) != e.V {
+			log.Fatalf("ERROR: vocabulary has %d words instead of %d\n", len(e.Vocabulary), e.V)
+		}
+	}
+	if idsFn != "" {
+		e.IDs = model.ReadIDs(idsFn)
+		if len(e.IDs) != len(e.Theta) {
+			log.Fatalf("ERROR: %d document IDs for %d documents\n", len(e.IDs), len(e.Theta))
+		}
+	}
+
+	var err error
+	switch format {
+	case "json":
+		err = saveJSON(outfn, e)
+	case "npz":
+		err = saveNPZ(outfn, e)
+	case "npy":
+		err = saveNPY(outfn, e)
+	default:
+		fmt.Println("Unknown format:", format)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal("ERROR: unable to export model: ", err)
+	}
+}
@@ -3,9 +3,9 @@ package main
 import (
 	"flag"
 	"log"
-	"math/rand"
 	"os"
 
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
 	"bitbucket.org/sitfoxfly/ranklda/lda"
 	"bitbucket.org/sitfoxfly/ranklda/model"
 )
@@ -16,12 +16,10 @@ func ensureDir(dir string) {
 	}
 }
 
-func ensureFile(fn string) {
+func ensureWritable(fn string) {
 	if fn != "" {
-		if f, err := os.Create(fn); err == nil {
-			f.Close()
-		} else {
-			log.Panic("ERROR: unable to create model file", fn)
+		if err := atomicfile.CheckWritable(fn); err != nil {
+			log.Panic("ERROR: unable to create model file ", fn, ": ", err)
 		}
 	}
 }
@@ -56,22 +54,21 @@ func main() {
 	var modeldir string
 	var modelfn string
 	var ldaOutFn string
+	var binary bool
 
 	flag.StringVar(&seedfn, "assign", "", "Zs seed initializer")
 	flag.StringVar(&datafn, "data", "", "data file")
 	flag.StringVar(&modeldir, "model-dir", "", "model directory")
 	flag.StringVar(&modelfn, "model", "", "final model")
 	flag.StringVar(&ldaOutFn, "lda-output", "", "vanilla LDA model")
+	flag.BoolVar(&binary, "bin", false, "save the final model in the binary format")
 	flag.Parse()
 
 	ensureCondition(datafn != "")
 	ensureCondition(modelfn != "")
 
-	rand.Seed(init.Seed)
-
-	if modelfn != "" {
-		ensureFile(modelfn)
-	}
+	ensureWritable(modelfn)
+	ensureWritable(ldaOutFn)
 
 	if modeldir != "" {
 		ensureDir(modeldir)
@@ -87,14 +84,26 @@ func main() {
 		m = model.AssignedModel(data, init, assignments)
 	}
 
-	m.Optimize(settings, modeldir)
+	if err := m.Optimize(settings, modeldir); err != nil {
+		log.Fatal("ERROR: unable to save model snapshot: ", err)
+	}
 
 	if modelfn != "" {
-		m.Save(modelfn)
+		var err error
+		if binary {
+			err = m.SaveBinary(modelfn)
+		} else {
+			err = m.Save(modelfn)
+		}
+		if err != nil {
+			log.Fatal("ERROR: unable to save model file: ", err)
+		}
 	}
 
 	if ldaOutFn != "" {
-		m.SaveLDA(ldaOutFn)
+		if err := m.SaveLDA(ldaOutFn); err != nil {
+			log.Fatal("ERROR: unable to save LDA model: ", err)
+		}
 	}
 
 }
@@ -1,23 +1,32 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"math/rand"
-
+	"io"
+	"log"
+	"math"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
 	"bitbucket.org/sitfoxfly/ranklda/model"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
 )
 
-func save(fn string, z [][]int, scores []float64, perplexity float64) {
-	f, err := os.Create(fn)
-	if err != nil {
-		fmt.Println("Cannot save file!")
-		os.Exit(1)
-	}
-	defer f.Close()
+func save(fn string, z [][]int, scores []float64, perplexity float64) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		return write(f, z, scores, perplexity)
+	})
+}
 
+func write(f io.Writer, z [][]int, scores []float64, perplexity float64) error {
 	fmt.Fprintf(f, "%d\n", len(z))
 	for _, zi := range z {
 		for j, zij := range zi {
@@ -37,22 +46,340 @@ func save(fn string, z [][]int, scores []float64, perplexity float64) {
 			fmt.Fprintf(f, " %f", score)
 		}
 	}
-	fmt.Fprintf(f, "\n%f\n", perplexity)
+	_, err := fmt.Fprintf(f, "\n%f\n", perplexity)
+	return err
+}
+
+// record is a single document of the structured output
+type record struct {
+	Rank        int                  `json:"rank"`
+	Doc         int                  `json:"doc"`
+	ID          string               `json:"id,omitempty"`
+	Score       float64              `json:"score"`
+	Theta       []float64            `json:"theta,omitempty"`
+	Z           []int                `json:"z,omitempty"`
+	Uncertainty *model.ScoreInterval `json:"uncertainty,omitempty"`
+}
+
+// records builds the structured output, the ranking keeps only the top documents
+// sorted by score while the full result keeps all documents with their topics
+func records(ids []string, z [][]int, theta [][]float64, scores []float64, intervals []model.ScoreInterval, ranking bool, top int) []*record {
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+
+	result := make([]*record, len(scores))
+	for rank, i := range order {
+		r := &record{Rank: rank + 1, Doc: i, Score: scores[i]}
+		if ids != nil {
+			r.ID = ids[i]
+		}
+		if !ranking {
+			r.Theta = theta[i]
+			r.Z = z[i]
+		}
+		if intervals != nil {
+			r.Uncertainty = &intervals[i]
+		}
+		result[i] = r
+	}
+	if !ranking {
+		return result
+	}
+
+	ranked := make([]*record, 0, len(order))
+	for _, i := range order {
+		if top > 0 && len(ranked) == top {
+			break
+		}
+		ranked = append(ranked, result[i])
+	}
+	return ranked
+}
+
+func saveRecords(fn string, format string, rs []*record, ranking bool) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		if format == "jsonl" {
+			enc := json.NewEncoder(f)
+			for _, r := range rs {
+				if err := enc.Encode(r); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		fmt.Fprint(f, "rank\tdoc\tid\tscore")
+		if !ranking {
+			fmt.Fprint(f, "\ttheta\tz")
+		}
+		if len(rs) > 0 && rs[0].Uncertainty != nil {
+			fmt.Fprint(f, "\tmean\tstd\tlower\tupper")
+		}
+		fmt.Fprintln(f)
+		for _, r := range rs {
+			fmt.Fprintf(f, "%d\t%d\t%s\t%f", r.Rank, r.Doc, r.ID, r.Score)
+			if !ranking {
+				fmt.Fprint(f, "\t")
+				for k, x := range r.Theta {
+					if k > 0 {
+						fmt.Fprint(f, ",")
+					}
+					fmt.Fprintf(f, "%g", x)
+				}
+				fmt.Fprint(f, "\t")
+				for j, zij := range r.Z {
+					if j > 0 {
+						fmt.Fprint(f, " ")
+					}
+					fmt.Fprintf(f, "%d", zij)
+				}
+			}
+			if si := r.Uncertainty; si != nil {
+				fmt.Fprintf(f, "\t%f\t%f\t%f\t%f", si.Mean, si.Std, si.Lower, si.Upper)
+			}
+			fmt.Fprintln(f)
+		}
+		return nil
+	})
+}
+
+func saveIntervals(fn string, intervals []model.ScoreInterval) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		fmt.Fprintln(f, "doc\tmean\tstd\tlower\tupper")
+		for i, si := range intervals {
+			fmt.Fprintf(f, "%d\t%f\t%f\t%f\t%f\n", i, si.Mean, si.Std, si.Lower, si.Upper)
+		}
+		return nil
+	})
+}
+
+func saveHeldOut(fn string, h *model.HeldOut) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		fmt.Fprintln(f, "doc\tloglik\twords\tperplexity")
+		for i, l := range h.LogLik {
+			if h.Words[i] == 0 {
+				// empty documents, or too short to be split for completion, are not estimated
+				fmt.Fprintf(f, "%d\t-\t0\t-\n", i)
+				continue
+			}
+			fmt.Fprintf(f, "%d\t%f\t%d\t%f\n", i, l, h.Words[i], math.Exp(-l/float64(h.Words[i])))
+		}
+		return nil
+	})
+}
+
+// saveFastReport writes the agreement of the fast scores with the scores of the full inference
+func saveFastReport(fn string, fast, full []float64, fastTime, fullTime time.Duration) error {
+	spearman := umath.Spearman(fast, full)
+	kendall := umath.KendallTau(fast, full)
+	log.Printf("fast scorer: spearman = %f, kendall tau = %f\n", spearman, kendall)
+	meanAbsDiff := 0.0
+	for i := range fast {
+		meanAbsDiff += math.Abs(fast[i] - full[i])
+	}
+	meanAbsDiff /= float64(len(fast))
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		fmt.Fprintf(f, "docs\t%d\n", len(fast))
+		fmt.Fprintf(f, "spearman\t%f\n", spearman)
+		fmt.Fprintf(f, "kendall_tau\t%f\n", kendall)
+		fmt.Fprintf(f, "pearson\t%f\n", umath.Pearson(fast, full))
+		fmt.Fprintf(f, "mean_abs_diff\t%f\n", meanAbsDiff)
+		fmt.Fprintf(f, "fast_seconds\t%f\n", fastTime.Seconds())
+		_, err := fmt.Fprintf(f, "full_seconds\t%f\n", fullTime.Seconds())
+		return err
+	})
+}
+
+// readAgainst reads the comparisons with the training documents, one per line
+// as "<winner> <loser>" where the training document is prefixed with "t", e.g. "3 t17"
+func readAgainst(fn string, n, numTrain int) []model.Against {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatal("ERROR: unable to open comparisons file", err)
+	}
+	defer f.Close()
+	result := make([]model.Against, 0, 1024)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "t") == strings.HasPrefix(fields[1], "t") {
+			log.Fatalf("ERROR: line %d must compare an unseen and a training document\n", line)
+		}
+		a := model.Against{Wins: strings.HasPrefix(fields[1], "t")}
+		doc, train := fields[0], fields[1]
+		if !a.Wins {
+			doc, train = train, doc
+		}
+		var err1, err2 error
+		a.Doc, err1 = strconv.Atoi(doc)
+		a.Train, err2 = strconv.Atoi(strings.TrimPrefix(train, "t"))
+		if err1 != nil || err2 != nil || a.Doc < 0 || a.Doc >= n || a.Train < 0 || a.Train >= numTrain {
+			log.Fatalf("ERROR: invalid comparison at line %d\n", line)
+		}
+		result = append(result, a)
+	}
+	if sc.Err() != nil {
+		log.Fatal("ERROR: unable to read comparisons file", sc.Err())
+	}
+	return result
+}
+
+// result is a single document of the streaming output
+type result struct {
+	Line  int       `json:"line"`
+	Score *float64  `json:"score,omitempty"`
+	Theta []float64 `json:"theta,omitempty"`
+	Z     []int     `json:"z,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// stream infers and scores the documents one per line as they arrive, every line
+// gets its own random stream and its JSON result is flushed immediately,
+// the fast scorer skips the inference of topic assignments
+func stream(p *model.Predictor, settings *model.InferSettings, fast bool, r io.Reader, w io.Writer) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	v := p.V()
+	for line := 0; sc.Scan(); line++ {
+		res := &result{Line: line}
+		if doc, err := model.ParseDoc(sc.Text()); err != nil {
+			res.Error = err.Error()
+		} else {
+			filtered := doc[:0]
+			for _, w := range doc {
+				if w < v {
+					filtered = append(filtered, w)
+				}
+			}
+			if fast {
+				res.Theta = p.FastThetaDoc(filtered)
+			} else {
+				res.Z, res.Theta = p.InferDocPosterior(filtered, settings, umath.NewRand(settings.Seed, line))
+			}
+			score := p.Score([][]float64{res.Theta})[0]
+			res.Score = &score
+		}
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
 }
 
 func main() {
-	var seed int64
 	settings := &model.InferSettings{}
 
-	flag.Int64Var(&seed, "s", 1, "random seed")
+	flag.Int64Var(&settings.Seed, "s", 1, "random seed")
 
 	flag.Float64Var(&settings.InitT, "t", 1.0, "initial temperature")
 	flag.IntVar(&settings.NumSAIter, "ti", 1000, "number of iterations for SA optimization")
 	flag.Float64Var(&settings.CoolingRate, "tg", 1.0, "global cooling rate")
+	flag.IntVar(&settings.NumSamples, "samples", 50, "number of posterior samples of topic proportions after annealing")
+	flag.IntVar(&settings.SampleLag, "lag", 1, "number of sweeps between posterior samples")
+	flag.IntVar(&settings.Threads, "threads", runtime.NumCPU(), "number of inference workers")
 	var modelDataFn string
-	flag.StringVar(&modelDataFn, "data", "", "model data file")
+	flag.StringVar(&modelDataFn, "data", "", "model data file (for models saved without topic counts and for the uncertainty of nu)")
+	uncertainty := &model.UncertaintySettings{}
+	var uncertaintyFn string
+	flag.StringVar(&uncertaintyFn, "uncertainty", "", "output file of score means, deviations and credible intervals")
+	flag.IntVar(&uncertainty.NumChains, "chains", 10, "number of inference chains per document for the score uncertainty")
+	flag.IntVar(&uncertainty.NumDraws, "draws", 1000, "number of score draws per document for the score uncertainty")
+	flag.Float64Var(&uncertainty.Level, "level", 0.95, "credible interval level")
+	var format string
+	var idsFn string
+	var ranking bool
+	var top int
+	flag.StringVar(&format, "format", "", "output format: plain (positional z, scores and perplexity), tsv or jsonl (default: plain, tsv for ranking)")
+	flag.StringVar(&idsFn, "ids", "", "document IDs file, one ID per line")
+	flag.BoolVar(&ranking, "rank", false, "output documents sorted by score")
+	flag.IntVar(&top, "top", 0, "output only the top-k documents of the ranking (implies -rank)")
+	var transductive bool
+	var againstFn string
+	flag.BoolVar(&transductive, "transductive", false, "use the comparisons among the documents of the data file during inference")
+	flag.StringVar(&againstFn, "against", "", "comparisons with the training documents, e.g. \"3 t17\" (implies -transductive)")
+	var streaming bool
+	flag.BoolVar(&streaming, "stream", false, "read documents from stdin one per line and write JSON results to stdout")
+	var fast bool
+	var fastReportFn string
+	flag.BoolVar(&fast, "fast", false, "approximate the topic proportions and scores in one pass over the words instead of annealing")
+	flag.StringVar(&fastReportFn, "fastreport", "", "output file of the rank correlation between the fast and the full scores")
+	var heldOutMethod, heldOutFn string
+	var numParticles, numISSamples int
+	flag.StringVar(&heldOutMethod, "heldout", "random", "estimator of the held-out log-likelihood per word: random (average over uniform assignments), completion, ltr (left-to-right) or is (importance sampling)")
+	flag.IntVar(&numParticles, "particles", 20, "number of particles of the left-to-right estimator")
+	flag.IntVar(&numISSamples, "issamples", 1000, "number of prior samples of the importance sampling estimator")
+	flag.StringVar(&heldOutFn, "heldoutdocs", "", "output file of the per-document held-out log-likelihoods")
 	flag.Parse()
 
+	if streaming {
+		if flag.NArg() != 1 {
+			fmt.Println("USAGE: rldainf -stream <model> < docs > results")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		var m *model.Model
+		if modelDataFn == "" {
+			m = model.ReadModel(flag.Arg(0))
+		} else {
+			m = model.ReadModelWithData(flag.Arg(0), modelDataFn)
+		}
+		p, err := model.NewPredictor(m)
+		if err != nil {
+			log.Fatal("ERROR: ", err)
+		}
+		if err := stream(p, settings, fast, os.Stdin, os.Stdout); err != nil {
+			log.Fatal("ERROR: ", err)
+		}
+		return
+	}
+
+	if top > 0 {
+		ranking = true
+	}
+	if heldOutMethod != "random" && heldOutMethod != "completion" && heldOutMethod != "ltr" && heldOutMethod != "is" ||
+		heldOutMethod == "random" && heldOutFn != "" {
+		fmt.Println("Unsupported held-out estimator:", heldOutMethod)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if fast && (transductive || againstFn != "" || uncertaintyFn != "") {
+		fmt.Println("The fast scorer supports neither transductive inference nor the score uncertainty")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if heldOutMethod == "ltr" && numParticles < 1 || heldOutMethod == "is" && numISSamples < 1 {
+		log.Fatalf("ERROR: the held-out estimator requires at least one particle and one sample (-particles %d, -issamples %d)\n",
+			numParticles, numISSamples)
+	}
+	if uncertaintyFn != "" {
+		if uncertainty.NumChains < 1 || uncertainty.NumDraws < 1 {
+			log.Fatalf("ERROR: the score uncertainty requires at least one chain and one draw (-chains %d, -draws %d)\n",
+				uncertainty.NumChains, uncertainty.NumDraws)
+		}
+		if uncertainty.Level <= 0 || uncertainty.Level >= 1 {
+			log.Fatalf("ERROR: the credible interval level must be in (0, 1), got %f\n", uncertainty.Level)
+		}
+	}
+	if format == "" {
+		if ranking {
+			format = "tsv"
+		} else {
+			format = "plain"
+		}
+	}
+	if format != "plain" && format != "tsv" && format != "jsonl" || format == "plain" && ranking {
+		fmt.Println("Unsupported output format:", format)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
 	if flag.NArg() != 3 {
 		fmt.Println("USAGE: rldainf <model> <data> <output>")
 		flag.PrintDefaults()
@@ -63,19 +390,111 @@ func main() {
 	datafn := flag.Arg(1)
 	outfn := flag.Arg(2)
 
-	rand.Seed(seed)
-
 	var m *model.Model
 	if modelDataFn == "" {
 		m = model.ReadModel(modelfn)
 	} else {
 		m = model.ReadModelWithData(modelfn, modelDataFn)
 	}
+	p, err := model.NewPredictor(m)
+	if err != nil {
+		log.Fatal("ERROR: ", err)
+	}
 	data := model.Reduce(model.ReadData(datafn), m)
+	var ids []string
+	if idsFn != "" {
+		ids = model.ReadIDs(idsFn)
+		if len(ids) != data.N {
+			log.Fatalf("ERROR: %d document IDs for %d documents\n", len(ids), data.N)
+		}
+	}
+
+	var fastTheta [][]float64
+	var fastScores []float64
+	var fastTime time.Duration
+	if fast || fastReportFn != "" {
+		start := time.Now()
+		fastTheta = p.FastTheta(data)
+		fastScores = p.FastScore(data)
+		fastTime = time.Since(start)
+	}
+
+	var z [][]int
+	var theta [][]float64
+	var scores []float64
+	if !fast || fastReportFn != "" {
+		start := time.Now()
+		if transductive || againstFn != "" {
+			for i, c := range data.C {
+				if c.X < 0 || c.X >= data.N || c.Y < 0 || c.Y >= data.N {
+					log.Fatalf("ERROR: comparison %d refers to a missing document\n", i+1)
+				}
+			}
+			var against []model.Against
+			if againstFn != "" {
+				against = readAgainst(againstFn, data.N, len(m.Z()))
+			}
+			z, theta = p.InferTransductive(data, against, settings)
+		} else {
+			z, theta = p.InferPosterior(data, settings)
+		}
+		scores = p.Score(theta)
+		if fastReportFn != "" {
+			if err := saveFastReport(fastReportFn, fastScores, scores, fastTime, time.Since(start)); err != nil {
+				fmt.Println("Cannot save file:", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if fast {
+		// no topic assignments are inferred, the documents keep empty rows
+		z, theta, scores = make([][]int, data.N), fastTheta, fastScores
+	}
+	var perplexity float64
+	if heldOutMethod == "random" {
+		perplexity = p.Perplexity2(data.W, settings.Seed)
+	} else {
+		var h *model.HeldOut
+		switch heldOutMethod {
+		case "completion":
+			h = p.CompletionLikelihood(data, settings)
+		case "ltr":
+			h = p.LeftToRight(data, numParticles, settings)
+		case "is":
+			h = p.ImportanceSampling(data, numISSamples, settings)
+		}
+		perplexity = h.LogLikPerWord()
+		log.Printf("held-out perplexity (%s) = %f\n", heldOutMethod, h.Perplexity())
+		if heldOutFn != "" {
+			if err := saveHeldOut(heldOutFn, h); err != nil {
+				fmt.Println("Cannot save file:", err)
+				os.Exit(1)
+			}
+		}
+	}
 
-	z := m.Infer(data, settings)
-	scores := m.Score(z)
-	perplexity := m.Perplexity2(data.W)
+	var intervals []model.ScoreInterval
+	if uncertaintyFn != "" {
+		// nu is kept fixed unless the training comparisons and the prior variance of nu are available
+		precision := m.NuPrecision()
+		if precision == nil {
+			log.Println("WARNING: no training data or prior variance sigma for the uncertainty of nu, only topic assignments vary")
+		}
+		intervals = p.ScoreUncertainty(data, settings, uncertainty, precision)
+		if err := saveIntervals(uncertaintyFn, intervals); err != nil {
+			fmt.Println("Cannot save file:", err)
+			os.Exit(1)
+		}
+	}
 
-	save(outfn, z, scores, perplexity)
+	if format == "plain" {
+		err = save(outfn, z, scores, perplexity)
+	} else {
+		log.Printf("perplexity = %f\n", perplexity)
+		err = saveRecords(outfn, format, records(ids, z, theta, scores, intervals, ranking, top), ranking)
+	}
+	if err != nil {
+		fmt.Println("Cannot save file:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"bitbucket.org/sitfoxfly/ranklda/model"
+)
+
+// request is the JSON body of all endpoints, documents are lists of word ids
+type request struct {
+	Docs [][]int  `json:"docs"`
+	IDs  []string `json:"ids,omitempty"`
+	A    []int    `json:"a,omitempty"`
+	B    []int    `json:"b,omitempty"`
+	Top  int      `json:"top,omitempty"`
+}
+
+type inferResult struct {
+	Theta []float64 `json:"theta"`
+	Z     []int     `json:"z"`
+	Score float64   `json:"score"`
+}
+
+type rankResult struct {
+	Rank  int     `json:"rank"`
+	Doc   int     `json:"doc"`
+	ID    string  `json:"id,omitempty"`
+	Score float64 `json:"score"`
+}
+
+// endpointStats accumulates the latency of a single endpoint
+type endpointStats struct {
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	MeanMs    float64 `json:"mean_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	totalMsec float64
+}
+
+// limits bound the work of a single request
+type limits struct {
+	maxBody   int64
+	maxDocs   int
+	maxTokens int
+}
+
+// errTooLarge is returned for the requests over the limits
+var errTooLarge = errors.New("request too large")
+
+type server struct {
+	p        *model.Predictor
+	settings *model.InferSettings
+	limits   limits
+	mu       sync.Mutex
+	stats    map[string]*endpointStats
+}
+
+func newServer(p *model.Predictor, settings *model.InferSettings, l limits) http.Handler {
+	s := &server{p: p, settings: settings, limits: l, stats: make(map[string]*endpointStats)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/infer", s.handle("infer", s.infer))
+	mux.HandleFunc("/score", s.handle("score", s.score))
+	mux.HandleFunc("/compare", s.handle("compare", s.compare))
+	mux.HandleFunc("/rank", s.handle("rank", s.rank))
+	mux.HandleFunc("/metrics", s.metrics)
+	return mux
+}
+
+// handle decodes the request, calls the endpoint and records its latency
+func (s *server) handle(name string, endpoint func(req *request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		status := http.StatusOK
+		defer func() {
+			s.record(name, time.Since(start), status != http.StatusOK)
+		}()
+
+		if r.Method != http.MethodPost {
+			status = http.StatusMethodNotAllowed
+			writeError(w, status, errors.New("POST is required"))
+			return
+		}
+		req := &request{}
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.limits.maxBody))
+		if err := dec.Decode(req); err != nil {
+			status = http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeError(w, status, err)
+			return
+		}
+		resp, err := endpoint(req)
+		if err != nil {
+			status = http.StatusBadRequest
+			if errors.Is(err, errTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeError(w, status, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func (s *server) record(name string, latency time.Duration, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.stats[name]
+	if !ok {
+		st = &endpointStats{}
+		s.stats[name] = st
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+	st.Requests++
+	if failed {
+		st.Errors++
+	}
+	st.totalMsec += ms
+	st.MeanMs = st.totalMsec / float64(st.Requests)
+	if ms > st.MaxMs {
+		st.MaxMs = ms
+	}
+}
+
+func (s *server) metrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.stats)
+}
+
+// data validates the documents and drops the words outside of the model vocabulary
+func (s *server) data(docs [][]int) (*model.Data, error) {
+	if len(docs) > s.limits.maxDocs {
+		return nil, fmt.Errorf("%w: %d documents, at most %d are allowed", errTooLarge, len(docs), s.limits.maxDocs)
+	}
+	tokens := 0
+	for _, doc := range docs {
+		tokens += len(doc)
+	}
+	if tokens > s.limits.maxTokens {
+		return nil, fmt.Errorf("%w: %d tokens, at most %d are allowed", errTooLarge, tokens, s.limits.maxTokens)
+	}
+	v := s.p.V()
+	w := make([][]int, len(docs))
+	for i, doc := range docs {
+		w[i] = make([]int, 0, len(doc))
+		for _, word := range doc {
+			if word < 0 {
+				return nil, fmt.Errorf("negative word id in document %d", i)
+			}
+			if word < v {
+				w[i] = append(w[i], word)
+			}
+		}
+	}
+	return &model.Data{W: w, V: v, N: len(w)}, nil
+}
+
+func (s *server) infer(req *request) (interface{}, error) {
+	data, err := s.data(req.Docs)
+	if err != nil {
+		return nil, err
+	}
+	z, theta := s.p.InferPosterior(data, s.settings)
+	scores := s.p.Score(theta)
+	results := make([]inferResult, data.N)
+	for i := range results {
+		results[i] = inferResult{theta[i], z[i], scores[i]}
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+func (s *server) score(req *request) (interface{}, error) {
+	data, err := s.data(req.Docs)
+	if err != nil {
+		return nil, err
+	}
+	_, theta := s.p.InferPosterior(data, s.settings)
+	return map[string]interface{}{"scores": s.p.Score(theta)}, nil
+}
+
+func (s *server) compare(req *request) (interface{}, error) {
+	if req.A == nil || req.B == nil {
+		return nil, errors.New("documents a and b are required")
+	}
+	data, err := s.data([][]int{req.A, req.B})
+	if err != nil {
+		return nil, err
+	}
+	_, theta := s.p.InferPosterior(data, s.settings)
+	return map[string]float64{"probability": s.p.CompareDocs(theta[0], theta[1])}, nil
+}
+
+func (s *server) rank(req *request) (interface{}, error) {
+	if req.IDs != nil && len(req.IDs) != len(req.Docs) {
+		return nil, fmt.Errorf("%d ids for %d documents", len(req.IDs), len(req.Docs))
+	}
+	data, err := s.data(req.Docs)
+	if err != nil {
+		return nil, err
+	}
+	_, theta := s.p.InferPosterior(data, s.settings)
+	scores := s.p.Score(theta)
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	if req.Top > 0 && req.Top < len(order) {
+		order = order[:req.Top]
+	}
+	results := make([]rankResult, len(order))
+	for rank, i := range order {
+		results[rank] = rankResult{Rank: rank + 1, Doc: i, Score: scores[i]}
+		if req.IDs != nil {
+			results[rank].ID = req.IDs[i]
+		}
+	}
+	return map[string]interface{}{"ranking": results}, nil
+}
+
+func main() {
+	settings := &model.InferSettings{}
+
+	flag.Int64Var(&settings.Seed, "s", 1, "random seed")
+	flag.Float64Var(&settings.InitT, "t", 1.0, "initial temperature")
+	flag.IntVar(&settings.NumSAIter, "ti", 1000, "number of iterations for SA optimization")
+	flag.Float64Var(&settings.CoolingRate, "tg", 1.0, "global cooling rate")
+	flag.IntVar(&settings.NumSamples, "samples", 50, "number of posterior samples of topic proportions after annealing")
+	flag.IntVar(&settings.SampleLag, "lag", 1, "number of sweeps between posterior samples")
+	flag.IntVar(&settings.Threads, "threads", runtime.NumCPU(), "number of inference workers per request")
+	var addr string
+	l := limits{}
+	flag.StringVar(&addr, "addr", ":8080", "listen address")
+	flag.Int64Var(&l.maxBody, "max-body", 1<<20, "maximum request body size in bytes")
+	flag.IntVar(&l.maxDocs, "max-docs", 1000, "maximum number of documents per request")
+	flag.IntVar(&l.maxTokens, "max-tokens", 100000, "maximum number of tokens of all documents per request")
+	var readTimeout, writeTimeout, idleTimeout time.Duration
+	flag.DurationVar(&readTimeout, "read-timeout", 30*time.Second, "maximum duration of reading a request")
+	flag.DurationVar(&writeTimeout, "write-timeout", 5*time.Minute, "maximum duration of a request from the end of its headers to the end of the response, inference included")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 2*time.Minute, "maximum time to wait for the next request on a keep-alive connection")
+	var modelDataFn string
+	flag.StringVar(&modelDataFn, "data", "", "model data file (only for models saved without topic counts)")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("USAGE: rldaserve <model>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var m *model.Model
+	if modelDataFn == "" {
+		m = model.ReadModel(flag.Arg(0))
+	} else {
+		m = model.ReadModelWithData(flag.Arg(0), modelDataFn)
+	}
+	// the predictor is immutable, so the handlers share it without locking
+	p, err := model.NewPredictor(m)
+	if err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      newServer(p, settings, l),
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+	log.Printf("serving %s on %s\n", flag.Arg(0), addr)
+	log.Fatal(srv.ListenAndServe())
+}
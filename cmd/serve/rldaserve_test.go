@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/sitfoxfly/ranklda/model"
+)
+
+// testModel has two topics over four words, words 0 and 1 belong to the positive topic,
+// words 2 and 3 to the negative one
+const testModel = `2 4
+0.5 0.5
+0.01
+-0.7 -0.7 -10 -10
+-10 -10 -0.7 -0.7
+2 -2
+0
+20 20
+10 10 0 0
+0 0 10 10
+`
+
+func newTestServer(t *testing.T) http.Handler {
+	fn := filepath.Join(t.TempDir(), "model.txt")
+	if err := os.WriteFile(fn, []byte(testModel), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := model.NewPredictor(model.ReadModel(fn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := &model.InferSettings{Seed: 1, InitT: 1, NumSAIter: 20, CoolingRate: 1, NumSamples: 10, SampleLag: 1, Threads: 2}
+	return newServer(p, settings, limits{maxBody: 256, maxDocs: 3, maxTokens: 12})
+}
+
+func post(t *testing.T, h http.Handler, path, body string) (int, map[string]json.RawMessage) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	resp := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: invalid response %q: %v", path, rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestScore(t *testing.T) {
+	h := newTestServer(t)
+	code, resp := post(t, h, "/score", `{"docs": [[0, 1, 0, 1], [2, 3, 2, 3], [0, 99]]}`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, resp["error"])
+	}
+	var scores []float64
+	if err := json.Unmarshal(resp["scores"], &scores); err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 3 {
+		t.Fatalf("%d scores for 3 documents", len(scores))
+	}
+	if scores[0] <= 0 || scores[1] >= 0 || scores[2] <= 0 {
+		t.Errorf("scores = %v, want positive, negative and positive", scores)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	h := newTestServer(t)
+	code, resp := post(t, h, "/compare", `{"a": [0, 1, 1], "b": [2, 3, 3]}`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, resp["error"])
+	}
+	var prob float64
+	if err := json.Unmarshal(resp["probability"], &prob); err != nil {
+		t.Fatal(err)
+	}
+	if prob <= 0.5 || prob > 1 {
+		t.Errorf("probability = %v, want above 0.5", prob)
+	}
+
+	if code, _ := post(t, h, "/compare", `{"a": [0, 1]}`); code != http.StatusBadRequest {
+		t.Errorf("missing document: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	h := newTestServer(t)
+	for _, c := range []struct {
+		name, path, body string
+		code             int
+	}{
+		{"malformed score", "/score", `{"docs": [[0, 1]`, http.StatusBadRequest},
+		{"malformed compare", "/compare", `{"a": "x", "b": [1]}`, http.StatusBadRequest},
+		{"negative word", "/score", `{"docs": [[0, -1]]}`, http.StatusBadRequest},
+		{"oversize body", "/score", `{"docs": [[` + strings.Repeat("0, ", 200) + `0]]}`, http.StatusRequestEntityTooLarge},
+		{"too many documents", "/score", `{"docs": [[0], [1], [2], [3]]}`, http.StatusRequestEntityTooLarge},
+		{"too many tokens", "/compare", `{"a": [0, 0, 0, 0, 0, 0, 0], "b": [1, 1, 1, 1, 1, 1]}`, http.StatusRequestEntityTooLarge},
+	} {
+		code, resp := post(t, h, c.path, c.body)
+		if code != c.code {
+			t.Errorf("%s: status %d, want %d", c.name, code, c.code)
+		}
+		if _, ok := resp["error"]; !ok {
+			t.Errorf("%s: no error in the response", c.name)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/score", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"bitbucket.org/sitfoxfly/ranklda/model"
+	"bitbucket.org/sitfoxfly/ranklda/topics"
+)
+
+func main() {
+	var n int
+	var minShare float64
+	var vocabFn string
+	flag.IntVar(&n, "n", 10, "number of top words of every topic")
+	flag.Float64Var(&minShare, "coverage", 0, "share of the document tokens above which the topic covers the document")
+	flag.StringVar(&vocabFn, "vocab", "", "vocabulary file, one word per line")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("USAGE: rldatopics <model>")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	m := model.ReadModel(flag.Arg(0))
+	var vocab []string
+	if vocabFn != "" {
+		vocab = model.ReadIDs(vocabFn)
+		if len(vocab) < m.V() {
+			log.Fatalf("ERROR: %d vocabulary entries for %d words\n", len(vocab), m.V())
+		}
+	}
+
+	k := m.K()
+	nu := m.Nu()
+	beta := m.Beta()
+	top := topics.TopWords(m.LogPhi(), n)
+	tokens, _ := topics.Prevalence(m.Z(), k)
+	coverage := topics.Coverage(m.Z(), k, minShare)
+
+	// the topics driving documents to win come first, the ones driving them to lose last
+	order := make([]int, k)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return nu[order[a]] > nu[order[b]] })
+
+	out := bufio.NewWriter(os.Stdout)
+	fmt.Fprintln(out, "topic\tnu\tbeta\ttokens\tcoverage\twords")
+	for _, i := range order {
+		fmt.Fprintf(out, "%d\t%f\t%f\t%f\t%f\t%s\n", i, nu[i], beta[i], tokens[i], coverage[i], topics.Words(top[i], vocab))
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+}
@@ -0,0 +1,53 @@
+package estimate
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/gonum/floats"
+)
+
+// Annealed importance sampling (Neal, 2001) as applied to topic models by Wallach et al. (2009):
+// every run starts from the prior p(z) and moves through the tempered posteriors
+// p(z) p(w|z)^tau for a linear schedule of tau from 0 to 1, accumulating the importance weight
+
+// samplePrior draws the topic assignments from p(z) with theta integrated out (Polya urn)
+func samplePrior(lda *LDA, z []int, zn []int, rng *rand.Rand) {
+	p := make([]float64, len(zn))
+	for i := range z {
+		for j := range p {
+			p[j] = float64(zn[j]) + lda.Beta[j]
+		}
+		floats.Scale(1/floats.Sum(p), p)
+		z[i] = sample(p, rng)
+		zn[z[i]]++
+	}
+}
+
+// AISDoc estimates the log-likelihood of the document in nats averaging the importance weights
+// of s.NumSamples independent runs of s.NumTemps temperatures each
+func AISDoc(lda *LDA, doc []int, s *Settings, rng *rand.Rand) float64 {
+	k := lda.K()
+	n := len(doc)
+	weights := make([]float64, s.NumSamples)
+	for r := range weights {
+		z := make([]int, n)
+		zn := make([]int, k)
+		samplePrior(lda, z, zn, rng)
+		prevTau := 0.0
+		for t := 1; t <= s.NumTemps; t++ {
+			tau := float64(t) / float64(s.NumTemps)
+			weights[r] += (tau - prevTau) * logPwz(lda, doc, z)
+			sampleGibbsForward(lda, doc, z, zn, tau, rng)
+			prevTau = tau
+		}
+	}
+	return floats.LogSumExp(weights) - math.Log(float64(s.NumSamples))
+}
+
+// AIS estimates the log-likelihoods of the documents in nats by annealed importance sampling
+func AIS(lda *LDA, docs [][]int, s *Settings) []float64 {
+	return estimateDocs(docs, s, func(doc []int, rng *rand.Rand) float64 {
+		return AISDoc(lda, doc, s, rng)
+	})
+}
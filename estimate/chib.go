@@ -0,0 +1,85 @@
+package estimate
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/gonum/floats"
+)
+
+// The Chib-style estimator of Wallach et al. (2009): the log-likelihood of a document is
+// log p(w|z*) + log p(z*) - log p(z*|w) at a high posterior assignment z*, the latter
+// estimated by the transition probabilities of a Markov chain run around z*
+
+// logTz returns the log-probability of the reverse Gibbs sweep from za to zb
+func logTz(lda *LDA, doc []int, za, zb []int, zn []int) float64 {
+	k := len(zn)
+	n := len(doc)
+	curZn := make([]int, k)
+	copy(curZn, zn)
+
+	val := 0.0
+	p := make([]float64, k)
+	for i := n - 1; i > -1; i-- {
+		curZn[za[i]]--
+		lda.topicDist(p, doc[i], curZn, 1)
+		val += math.Log(p[zb[i]])
+		curZn[zb[i]]++
+	}
+	return val
+}
+
+// ChibDoc estimates the log-likelihood of the document in nats
+func ChibDoc(lda *LDA, doc []int, s *Settings, rng *rand.Rand) float64 {
+	numSamples := s.NumSamples
+	k := lda.K()
+	n := len(doc)
+	z := make([]int, n)
+	zn := make([]int, k)
+
+	for i := 0; i < n; i++ {
+		z[i] = rng.Intn(k)
+		zn[z[i]]++
+	}
+
+	// burn-in first to get a good sample
+	for a := 0; a < s.BurnIn; a++ {
+		sampleGibbsForward(lda, doc, z, zn, 1, rng)
+	}
+
+	samples := make([][]int, 0, numSamples)
+	znIndex := make([][]int, 0, numSamples)
+	for i := 0; i < numSamples; i++ {
+		samples = append(samples, make([]int, n))
+		znIndex = append(znIndex, make([]int, k))
+	}
+	x := rng.Intn(numSamples)
+	copy(samples[x], z)
+	copy(znIndex[x], zn)
+	sampleGibbsBackward(lda, doc, samples[x], znIndex[x], 1, rng)
+	for i := x + 1; i < numSamples; i++ {
+		copy(samples[i], samples[i-1])
+		copy(znIndex[i], znIndex[i-1])
+		sampleGibbsForward(lda, doc, samples[i], znIndex[i], 1, rng)
+	}
+
+	for i := x - 1; i > -1; i-- {
+		copy(samples[i], samples[i+1])
+		copy(znIndex[i], znIndex[i+1])
+		sampleGibbsBackward(lda, doc, samples[i], znIndex[i], 1, rng)
+	}
+
+	tzs := make([]float64, 0, numSamples)
+	for _, sample := range samples {
+		tzs = append(tzs, logTz(lda, doc, z, sample, zn))
+	}
+
+	return logPwz(lda, doc, z) + logPz(lda, doc, zn) + math.Log(float64(numSamples)) - floats.LogSumExp(tzs)
+}
+
+// Chib estimates the log-likelihoods of the documents in nats
+func Chib(lda *LDA, docs [][]int, s *Settings) []float64 {
+	return estimateDocs(docs, s, func(doc []int, rng *rand.Rand) float64 {
+		return ChibDoc(lda, doc, s, rng)
+	})
+}
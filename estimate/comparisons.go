@@ -0,0 +1,94 @@
+package estimate
+
+import (
+	"math"
+	"math/rand"
+
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/parallel"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+	"github.com/gonum/floats"
+)
+
+// ComparisonLikelihood keeps the estimated held-out log-likelihood of the comparisons given the words
+type ComparisonLikelihood struct {
+	// LogLik is log p(C|W) of all comparisons jointly
+	LogLik float64
+	// PerComparison are the log marginal probabilities of the single comparisons
+	PerComparison []float64
+	// EffectiveSamples is the effective sample size (Σw)² / Σw² of the joint weights w_j = p(C|theta_j),
+	// close to 1 when a single sample dominates LogLik and the estimate has a high variance
+	EffectiveSamples float64
+}
+
+// posteriorProportions returns s.NumSamples topic frequencies z/n of the document sampled
+// from the posterior p(z|w) after s.BurnIn sweeps, as the comparisons of CompareLDA see them
+func posteriorProportions(lda *LDA, doc []int, s *Settings, rng *rand.Rand) [][]float64 {
+	k := lda.K()
+	n := len(doc)
+	z := make([]int, n)
+	zn := make([]int, k)
+	for i := 0; i < n; i++ {
+		z[i] = rng.Intn(k)
+		zn[z[i]]++
+	}
+	for a := 0; a < s.BurnIn; a++ {
+		sampleGibbsForward(lda, doc, z, zn, 1, rng)
+	}
+	samples := make([][]float64, s.NumSamples)
+	for i := range samples {
+		sampleGibbsForward(lda, doc, z, zn, 1, rng)
+		samples[i] = make([]float64, k)
+		if n > 0 {
+			for j, c := range zn {
+				samples[i][j] = float64(c) / float64(n)
+			}
+		}
+	}
+	return samples
+}
+
+// Comparisons estimates the held-out log-likelihood of the comparisons (X beats Y) among the
+// documents, the topic proportions are marginalized over s.NumSamples joint posterior samples
+// given the words, the topic weights lda.Nu and at least one sample are required
+func Comparisons(lda *LDA, docs [][]int, comps []ints.Pair, s *Settings) *ComparisonLikelihood {
+	needed := make([]bool, len(docs))
+	for _, c := range comps {
+		needed[c.X] = true
+		needed[c.Y] = true
+	}
+	samples := make([][][]float64, len(docs))
+	parallel.For(len(docs), s.Threads, func(i int) {
+		if needed[i] {
+			samples[i] = posteriorProportions(lda, docs[i], s, umath.NewRand(s.Seed, i))
+		}
+	})
+
+	result := &ComparisonLikelihood{PerComparison: make([]float64, len(comps))}
+	joint := make([]float64, s.NumSamples)
+	perSample := make([]float64, s.NumSamples)
+	diff := make([]float64, lda.K())
+	for i, c := range comps {
+		for j := range perSample {
+			floats.SubTo(diff, samples[c.X][j], samples[c.Y][j])
+			perSample[j] = umath.LogSigmoid(floats.Dot(lda.Nu, diff))
+			joint[j] += perSample[j]
+		}
+		result.PerComparison[i] = floats.LogSumExp(perSample) - math.Log(float64(s.NumSamples))
+	}
+	result.LogLik = floats.LogSumExp(joint) - math.Log(float64(s.NumSamples))
+	result.EffectiveSamples = effectiveSamples(joint)
+	return result
+}
+
+// effectiveSamples returns the effective sample size of the importance weights given by their logarithms
+func effectiveSamples(logWeights []float64) float64 {
+	max := floats.Max(logWeights)
+	sum, sumSq := 0.0, 0.0
+	for _, l := range logWeights {
+		w := math.Exp(l - max)
+		sum += w
+		sumSq += w * w
+	}
+	return sum * sum / sumSq
+}
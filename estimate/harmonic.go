@@ -0,0 +1,39 @@
+package estimate
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/gonum/floats"
+)
+
+// HarmonicMeanDoc estimates the log-likelihood of the document in nats by the harmonic mean
+// of p(w|z) over s.NumSamples posterior samples taken after s.BurnIn sweeps, the estimate
+// is known to be biased upwards and is kept for the comparison with the literature
+func HarmonicMeanDoc(lda *LDA, doc []int, s *Settings, rng *rand.Rand) float64 {
+	k := lda.K()
+	n := len(doc)
+	z := make([]int, n)
+	zn := make([]int, k)
+	for i := 0; i < n; i++ {
+		z[i] = rng.Intn(k)
+		zn[z[i]]++
+	}
+	for a := 0; a < s.BurnIn; a++ {
+		sampleGibbsForward(lda, doc, z, zn, 1, rng)
+	}
+
+	neg := make([]float64, s.NumSamples)
+	for i := range neg {
+		sampleGibbsForward(lda, doc, z, zn, 1, rng)
+		neg[i] = -logPwz(lda, doc, z)
+	}
+	return math.Log(float64(s.NumSamples)) - floats.LogSumExp(neg)
+}
+
+// HarmonicMean estimates the log-likelihoods of the documents in nats by the harmonic mean method
+func HarmonicMean(lda *LDA, docs [][]int, s *Settings) []float64 {
+	return estimateDocs(docs, s, func(doc []int, rng *rand.Rand) float64 {
+		return HarmonicMeanDoc(lda, doc, s, rng)
+	})
+}
@@ -0,0 +1,256 @@
+package estimate
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/model"
+	"bitbucket.org/sitfoxfly/ranklda/parallel"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+	"github.com/gonum/floats"
+)
+
+// Settings - settings of the likelihood estimators
+type Settings struct {
+	Seed       int64
+	NumSamples int
+	BurnIn     int
+	NumTemps   int
+	Threads    int
+}
+
+// LDA is a topic model with fixed topics whose likelihood of documents is estimated
+type LDA struct {
+	// Beta is the document-topic Dirichlet prior, the learned beta of CompareLDA
+	// or the symmetric alpha of a plain LDA
+	Beta []float64
+	// Phi are the topic-word distributions
+	Phi [][]float64
+	// Nu are the topic weights of the comparisons, the learned nu of CompareLDA
+	// or the Bradley-Terry weights of a two-stage model, nil if comparisons are not modelled
+	Nu []float64
+}
+
+// FromModel takes the topics and the asymmetric document-topic prior of the model
+func FromModel(m *model.Model) *LDA {
+	phi := make([][]float64, m.K())
+	for i, row := range m.LogPhi() {
+		phi[i] = make([]float64, len(row))
+		for j, x := range row {
+			phi[i][j] = math.Exp(x)
+		}
+		floats.Scale(1/floats.Sum(phi[i]), phi[i])
+	}
+	return &LDA{Beta: append([]float64(nil), m.Beta()...), Phi: phi, Nu: append([]float64(nil), m.Nu()...)}
+}
+
+// Symmetric returns the symmetric prior alpha over k topics
+func Symmetric(alpha float64, k int) []float64 {
+	beta := make([]float64, k)
+	for i := range beta {
+		beta[i] = alpha
+	}
+	return beta
+}
+
+// K returns the number of topics
+func (lda *LDA) K() int {
+	return len(lda.Phi)
+}
+
+// V returns the vocabulary size
+func (lda *LDA) V() int {
+	return len(lda.Phi[0])
+}
+
+func parseFloats(line string, isLog bool) []float64 {
+	sc := bufio.NewScanner(strings.NewReader(line))
+	parse := make([]float64, 0, 64)
+	sc.Split(bufio.ScanWords)
+	for sc.Scan() {
+		if val, err := strconv.ParseFloat(sc.Text(), 64); err == nil {
+			if isLog {
+				val = math.Exp(val)
+			}
+			parse = append(parse, val)
+		} else {
+			log.Panic("ERROR: unable to parse floats", err)
+		}
+	}
+	if isLog {
+		floats.Scale(1/floats.Sum(parse), parse)
+	}
+	return parse
+}
+
+// ReadPhi reads the topic-word distributions one topic per line, isLog requires the exp transformation
+func ReadPhi(fn string, isLog bool) [][]float64 {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	phi := make([][]float64, 0, 10)
+	sc := bufio.NewScanner(f)
+	buf := make([]byte, 0, 64*1024)
+	sc.Buffer(buf, 1024*1024)
+	for sc.Scan() {
+		phi = append(phi, parseFloats(sc.Text(), isLog))
+	}
+	if sc.Err() != nil {
+		log.Panic("ERROR: unable to parse data file", sc.Err().Error())
+	}
+	return phi
+}
+
+// ReadNu reads the topic weights of the comparisons given on a single line
+func ReadNu(fn string) []float64 {
+	rows := ReadPhi(fn, false)
+	if len(rows) != 1 {
+		log.Fatalf("ERROR: %d lines of topic weights, expected one\n", len(rows))
+	}
+	return rows[0]
+}
+
+// Smooth adds the smoothing to every topic-word probability and renormalizes the topics
+func Smooth(phis [][]float64, smoothing float64) {
+	for _, phi := range phis {
+		floats.AddConst(smoothing, phi)
+		floats.Scale(1.0/floats.Sum(phi), phi)
+	}
+}
+
+func parseAndFilterInts(s string, v int) []int {
+	sc := bufio.NewScanner(strings.NewReader(s))
+	sc.Split(bufio.ScanWords)
+	result := make([]int, 0, 10)
+	for sc.Scan() {
+		if val, err := strconv.Atoi(sc.Text()); err == nil {
+			if val < v {
+				result = append(result, val)
+			}
+		} else {
+			log.Panic("ERROR: unable to parse data file", err)
+		}
+	}
+	return result
+}
+
+// ReadDocs reads the documents as word ids one document per line dropping the words outside of the vocabulary
+func ReadDocs(fn string, v int) [][]int {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	docs := make([][]int, 0, 10)
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		docs = append(docs, parseAndFilterInts(sc.Text(), v))
+	}
+
+	return docs
+}
+
+// ReadCorpus reads the documents and the comparisons of the standard corpus format
+// dropping the words outside of the vocabulary
+func ReadCorpus(fn string, v int) ([][]int, []ints.Pair) {
+	data := model.ReadData(fn)
+	docs := make([][]int, data.N)
+	for i, ws := range data.W {
+		docs[i] = make([]int, 0, len(ws))
+		for _, w := range ws {
+			if w < v {
+				docs[i] = append(docs[i], w)
+			}
+		}
+	}
+	return docs, data.C
+}
+
+func sample(p []float64, rng *rand.Rand) int {
+	x := rng.Float64()
+	cda := 0.0
+	for i, pi := range p {
+		cda += pi
+		if x < cda {
+			return i
+		}
+	}
+	return len(p) - 1
+}
+
+// topicDist fills p with the Gibbs conditional of the topic of word w given the other topic counts zn,
+// the likelihood is tempered by tau as in p(z) p(w|z)^tau
+func (lda *LDA) topicDist(p []float64, w int, zn []int, tau float64) {
+	for j := range p {
+		phi := lda.Phi[j][w]
+		if tau != 1 {
+			phi = math.Pow(phi, tau)
+		}
+		p[j] = phi * (float64(zn[j]) + lda.Beta[j])
+	}
+	floats.Scale(1/floats.Sum(p), p)
+}
+
+func sampleGibbsForward(lda *LDA, doc []int, z []int, zn []int, tau float64, rng *rand.Rand) {
+	n := len(doc)
+	p := make([]float64, len(zn))
+	for i := 0; i < n; i++ {
+		zn[z[i]]--
+		lda.topicDist(p, doc[i], zn, tau)
+		newZ := sample(p, rng)
+		zn[newZ]++
+		z[i] = newZ
+	}
+}
+
+func sampleGibbsBackward(lda *LDA, doc []int, z []int, zn []int, tau float64, rng *rand.Rand) {
+	n := len(doc)
+	p := make([]float64, len(zn))
+	for i := n - 1; i > -1; i-- {
+		zn[z[i]]--
+		lda.topicDist(p, doc[i], zn, tau)
+		newZ := sample(p, rng)
+		zn[newZ]++
+		z[i] = newZ
+	}
+}
+
+// logPwz returns log p(w | z)
+func logPwz(lda *LDA, doc []int, z []int) float64 {
+	val := 0.0
+	for i, w := range doc {
+		val += math.Log(lda.Phi[z[i]][w])
+	}
+	return val
+}
+
+// logPz returns log p(z) with theta integrated out
+func logPz(lda *LDA, doc []int, zn []int) float64 {
+	betaSum := floats.Sum(lda.Beta)
+	val := umath.Lgamma(betaSum) - umath.Lgamma(betaSum+float64(len(doc)))
+	for j, zni := range zn {
+		val += umath.Lgamma(float64(zni)+lda.Beta[j]) - umath.Lgamma(lda.Beta[j])
+	}
+	return val
+}
+
+// estimateDocs runs the estimator of a single document over the documents by s.Threads workers,
+// every document uses its own random stream derived from the seed
+func estimateDocs(docs [][]int, s *Settings, f func(doc []int, rng *rand.Rand) float64) []float64 {
+	result := make([]float64, len(docs))
+	parallel.For(len(docs), s.Threads, func(i int) {
+		result[i] = f(docs[i], umath.NewRand(s.Seed, i))
+	})
+	return result
+}
@@ -0,0 +1,271 @@
+package model
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
+)
+
+// binaryMagic opens every binary model file
+const binaryMagic = "CLDA"
+
+// binaryVersion is the current version of the binary model format
+const binaryVersion uint32 = 1
+
+// the limits of the dimensions of a binary model, the larger ones come from corrupt files
+const (
+	maxBinaryTopics = 1 << 16
+	maxBinaryVocab  = 1 << 28
+	maxBinaryDocs   = 1 << 31
+	maxBinaryDocLen = 1 << 31
+)
+
+// binaryChunk is the number of values allocated at once while reading, so that a corrupt
+// length fails at the end of the file instead of allocating all memory
+const binaryChunk = 1 << 16
+
+// binaryHeader keeps the dimensions and all hyperparameters of the model
+type binaryHeader struct {
+	Version            uint32
+	K                  int64
+	V                  int64
+	N                  int64
+	HasCounts          bool
+	Seed               int64
+	Alpha              float64
+	Sigma              float64
+	NumIter            int64
+	NumSAIter          int64
+	BetaOpt            bool
+	BurnInIter         int64
+	InitT              float64
+	LocalCRate         float64
+	GlobalCRate        float64
+	ComparisonDropRate float64
+}
+
+type binaryWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	err error
+}
+
+func (bw *binaryWriter) write(data interface{}) {
+	if bw.err == nil {
+		bw.err = binary.Write(io.MultiWriter(bw.w, bw.crc), binary.LittleEndian, data)
+	}
+}
+
+type binaryReader struct {
+	r   io.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (br *binaryReader) read(data interface{}) {
+	if br.err == nil {
+		br.err = binary.Read(io.TeeReader(br.r, br.crc), binary.LittleEndian, data)
+	}
+}
+
+// readFloat64s reads n values growing the result by chunks
+func (br *binaryReader) readFloat64s(n int) []float64 {
+	result := make([]float64, 0, minInt(n, binaryChunk))
+	for len(result) < n && br.err == nil {
+		chunk := make([]float64, minInt(n-len(result), binaryChunk))
+		br.read(chunk)
+		result = append(result, chunk...)
+	}
+	return result
+}
+
+// readInts reads n int32 values growing the result by chunks
+func (br *binaryReader) readInts(n int) []int {
+	result := make([]int, 0, minInt(n, binaryChunk))
+	for len(result) < n && br.err == nil {
+		chunk := make([]int32, minInt(n-len(result), binaryChunk))
+		br.read(chunk)
+		result = append(result, fromInt32s(chunk)...)
+	}
+	return result
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func toInt32s(x []int) ([]int32, error) {
+	result := make([]int32, len(x))
+	for i, v := range x {
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return nil, fmt.Errorf("value %d out of the int32 range", v)
+		}
+		result[i] = int32(v)
+	}
+	return result, nil
+}
+
+// writeInts writes the values as int32, the values out of range fail the write
+func (bw *binaryWriter) writeInts(x []int) {
+	if bw.err == nil {
+		var values []int32
+		if values, bw.err = toInt32s(x); bw.err == nil {
+			bw.write(values)
+		}
+	}
+}
+
+func fromInt32s(x []int32) []int {
+	result := make([]int, len(x))
+	for i, v := range x {
+		result[i] = int(v)
+	}
+	return result
+}
+
+// IsBinary checks whether the model file is stored in the binary format
+func IsBinary(fn string) bool {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatal("ERROR: unable to open file", err)
+	}
+	defer f.Close()
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == binaryMagic
+}
+
+// SaveBinary saves the model in the versioned binary format with CRC32 checksum
+func (model *Model) SaveBinary(fn string) error {
+	return atomicfile.Write(fn, model.writeBinary)
+}
+
+func (model *Model) writeBinary(buf io.Writer) error {
+	counts, totals := model.topicCounts()
+	header := binaryHeader{
+		Version:            binaryVersion,
+		K:                  int64(model.k),
+		V:                  int64(model.vocabSize()),
+		N:                  int64(len(model.z)),
+		HasCounts:          counts != nil,
+		Seed:               model.seed,
+		Alpha:              model.alpha,
+		Sigma:              model.sigma,
+		NumIter:            int64(model.settings.NumIter),
+		NumSAIter:          int64(model.settings.NumSAIter),
+		BetaOpt:            model.settings.BetaOpt,
+		BurnInIter:         int64(model.settings.BurnInIter),
+		InitT:              model.settings.InitT,
+		LocalCRate:         model.settings.LocalCRate,
+		GlobalCRate:        model.settings.GlobalCRate,
+		ComparisonDropRate: model.settings.ComparisonDropRate,
+	}
+
+	if _, err := io.WriteString(buf, binaryMagic); err != nil {
+		return err
+	}
+	bw := &binaryWriter{w: buf, crc: crc32.NewIEEE()}
+	bw.write(&header)
+	bw.write(model.beta)
+	for _, row := range model.logPhi {
+		bw.write(row)
+	}
+	bw.write(model.nu)
+	for _, row := range model.z {
+		bw.write(int64(len(row)))
+		bw.writeInts(row)
+	}
+	if header.HasCounts {
+		bw.writeInts(totals)
+		for _, row := range counts {
+			bw.writeInts(row)
+		}
+	}
+	if bw.err == nil {
+		bw.err = binary.Write(buf, binary.LittleEndian, bw.crc.Sum32())
+	}
+	return bw.err
+}
+
+// readBinaryModel reads the model in the binary format, the magic is already consumed
+func readBinaryModel(r io.Reader) *Model {
+	br := &binaryReader{r: r, crc: crc32.NewIEEE()}
+	var header binaryHeader
+	br.read(&header)
+	if br.err != nil {
+		log.Fatal("ERROR: unable to read model header: ", br.err)
+	}
+	if header.Version != binaryVersion {
+		log.Fatalf("ERROR: unsupported model version %d (expected %d)\n", header.Version, binaryVersion)
+	}
+
+	if header.K < 1 || header.K > maxBinaryTopics || header.V < 1 || header.V > maxBinaryVocab ||
+		header.N < 0 || header.N > maxBinaryDocs {
+		log.Fatalf("ERROR: corrupt model header: %d topics, %d words, %d documents\n", header.K, header.V, header.N)
+	}
+	k := int(header.K)
+	v := int(header.V)
+	model := &Model{}
+	model.k = k
+	model.alpha = header.Alpha
+	model.sigma = header.Sigma
+	model.seed = header.Seed
+	model.settings = OptSettings{
+		NumIter:            int(header.NumIter),
+		NumSAIter:          int(header.NumSAIter),
+		BetaOpt:            header.BetaOpt,
+		BurnInIter:         int(header.BurnInIter),
+		InitT:              header.InitT,
+		LocalCRate:         header.LocalCRate,
+		GlobalCRate:        header.GlobalCRate,
+		ComparisonDropRate: header.ComparisonDropRate,
+	}
+
+	model.beta = br.readFloat64s(k)
+	model.logPhi = make([][]float64, k)
+	for i := 0; i < k && br.err == nil; i++ {
+		model.logPhi[i] = br.readFloat64s(v)
+	}
+	model.nu = br.readFloat64s(k)
+	model.z = make([][]int, 0, minInt(int(header.N), binaryChunk))
+	for i := int64(0); i < header.N && br.err == nil; i++ {
+		var n int64
+		br.read(&n)
+		if br.err == nil && (n < 0 || n > maxBinaryDocLen) {
+			log.Fatalf("ERROR: corrupt model file: document %d of length %d\n", i, n)
+		}
+		model.z = append(model.z, br.readInts(int(n)))
+	}
+	if header.HasCounts {
+		model.totals = br.readInts(k)
+		model.counts = make([][]int, k)
+		for i := 0; i < k && br.err == nil; i++ {
+			model.counts[i] = br.readInts(v)
+		}
+	}
+	if br.err != nil {
+		log.Fatal("ERROR: unable to read model file: ", br.err)
+	}
+
+	expected := br.crc.Sum32()
+	var checksum uint32
+	if err := binary.Read(r, binary.LittleEndian, &checksum); err != nil {
+		log.Fatal("ERROR: unable to read model checksum: ", err)
+	}
+	if checksum != expected {
+		log.Fatalf("ERROR: model checksum mismatch: %08x != %08x\n", checksum, expected)
+	}
+	return model
+}
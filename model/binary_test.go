@@ -0,0 +1,79 @@
+package model
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func testModel() *Model {
+	data := &Data{W: [][]int{{0, 1, 2, 2}, {3, 1}, {}, {4, 4, 0}}, V: 5, N: 4}
+	model := RandomModel(data, &InitSet{Seed: 7, K: 3, Sigma: 1.5, Alpha: 0.1, Beta: 0.5})
+	model.settings = OptSettings{NumIter: 10, NumSAIter: 20, BetaOpt: true, InitT: 2, LocalCRate: 0.9, GlobalCRate: 0.8}
+	model.logPhi[1][2] = math.Log(0.3)
+	return model
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	model := testModel()
+	var buf bytes.Buffer
+	if err := model.writeBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(binaryMagic)) {
+		t.Fatal("magic missing")
+	}
+	buf.Next(len(binaryMagic))
+	got := readBinaryModel(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes left after the model", buf.Len())
+	}
+
+	if got.k != model.k || got.alpha != model.alpha || got.sigma != model.sigma || got.seed != model.seed {
+		t.Errorf("header mismatch: k=%d alpha=%v sigma=%v seed=%d", got.k, got.alpha, got.sigma, got.seed)
+	}
+	if got.settings != model.settings {
+		t.Errorf("settings = %+v, want %+v", got.settings, model.settings)
+	}
+	for name, pair := range map[string][2]interface{}{
+		"beta":   {got.beta, model.beta},
+		"logPhi": {got.logPhi, model.logPhi},
+		"nu":     {got.nu, model.nu},
+		"z":      {got.z, model.z},
+	} {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("%s = %v, want %v", name, pair[0], pair[1])
+		}
+	}
+	// the counts are rebuilt from the training data on write and stored in the file
+	counts, totals := model.countTopics()
+	if !reflect.DeepEqual(got.counts, counts) || !reflect.DeepEqual(got.totals, totals) {
+		t.Errorf("counts = %v %v, want %v %v", got.counts, got.totals, counts, totals)
+	}
+}
+
+func TestBinaryWithoutCounts(t *testing.T) {
+	model := testModel()
+	model.data = nil
+	var buf bytes.Buffer
+	if err := model.writeBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	buf.Next(len(binaryMagic))
+	if got := readBinaryModel(&buf); got.counts != nil || got.totals != nil {
+		t.Errorf("counts = %v %v, want none", got.counts, got.totals)
+	}
+}
+
+func TestBinaryInt32Range(t *testing.T) {
+	if _, err := toInt32s([]int{0, math.MaxInt32, math.MinInt32}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	model := testModel()
+	model.data = nil
+	model.z[0][0] = math.MaxInt32 + 1
+	if err := model.writeBinary(&bytes.Buffer{}); err == nil {
+		t.Error("expected an error for a value out of the int32 range")
+	}
+}
@@ -51,6 +51,48 @@ func parseLine(s string) []ints.Pair {
 	return result
 }
 
+// the limits of the documents parsed by ParseDoc
+const (
+	// MaxWordCount is the largest count of a word:count pair
+	MaxWordCount = 1 << 16
+	// MaxDocLength is the largest number of tokens of a document
+	MaxDocLength = 1 << 20
+)
+
+// ParseDoc parses a single document given either as word:count pairs or as word ids,
+// the documents over MaxWordCount or MaxDocLength are rejected
+func ParseDoc(s string) ([]int, error) {
+	doc := make([]int, 0, 16)
+	for _, token := range strings.Fields(s) {
+		if i := strings.IndexByte(token, ':'); i >= 0 {
+			w, err1 := strconv.Atoi(token[:i])
+			c, err2 := strconv.Atoi(token[i+1:])
+			if err1 != nil || err2 != nil || w < 0 || c < 0 {
+				return nil, fmt.Errorf("invalid word:count pair %q", token)
+			}
+			if c > MaxWordCount {
+				return nil, fmt.Errorf("word count %d of %q exceeds %d", c, token, MaxWordCount)
+			}
+			if len(doc)+c > MaxDocLength {
+				return nil, fmt.Errorf("document exceeds %d tokens", MaxDocLength)
+			}
+			for j := 0; j < c; j++ {
+				doc = append(doc, w)
+			}
+		} else {
+			w, err := strconv.Atoi(token)
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid word id %q", token)
+			}
+			if len(doc) >= MaxDocLength {
+				return nil, fmt.Errorf("document exceeds %d tokens", MaxDocLength)
+			}
+			doc = append(doc, w)
+		}
+	}
+	return doc, nil
+}
+
 // ReadData reads the data file
 func ReadData(fn string) *Data {
 	f, err := os.Open(fn)
@@ -104,13 +146,15 @@ func ReadData(fn string) *Data {
 	return &Data{docs, comparisons, vocabSize, len(docs), len(comparisons)}
 }
 
+// Reduce drops the words outside of the model vocabulary
 func Reduce(data *Data, model *Model) *Data {
-	data.V = model.data.V
+	v := model.vocabSize()
+	data.V = v
 	filtered := make([][]int, data.N)
 	for i, ws := range data.W {
 		filtered[i] = make([]int, 0, len(ws))
 		for _, w := range ws {
-			if w < model.data.V {
+			if w < v {
 				filtered[i] = append(filtered[i], w)
 			}
 		}
@@ -118,3 +162,46 @@ func Reduce(data *Data, model *Model) *Data {
 	data.W = filtered
 	return data
 }
+
+// ReadIDs reads the document or word identifiers, one per line
+func ReadIDs(fn string) []string {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatal("Unable to open identifiers file", err)
+	}
+	defer f.Close()
+	ids := make([]string, 0, 1024)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		ids = append(ids, sc.Text())
+	}
+	if sc.Err() != nil {
+		log.Fatal("Unable to read identifiers file", sc.Err())
+	}
+	return ids
+}
+
+// ReadPairs reads the pairs of documents, one "<a> <b>" per line, every document must be below n
+func ReadPairs(fn string, n int) []ints.Pair {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatal("ERROR: unable to open pairs file", err)
+	}
+	defer f.Close()
+	pairs := make([]ints.Pair, 0, 1024)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		p := ints.Pair{}
+		if _, err := fmt.Sscanf(sc.Text(), "%d %d", &p.X, &p.Y); err != nil {
+			log.Fatalf("ERROR: unable to parse pair at line %d: %v\n", line, err)
+		}
+		if p.X < 0 || p.X >= n || p.Y < 0 || p.Y >= n {
+			log.Fatalf("ERROR: pair at line %d refers to a missing document\n", line)
+		}
+		pairs = append(pairs, p)
+	}
+	if sc.Err() != nil {
+		log.Fatal("ERROR: unable to read pairs file", sc.Err())
+	}
+	return pairs
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDoc(t *testing.T) {
+	for _, c := range []struct {
+		s   string
+		doc []int
+	}{
+		{"3 1 3", []int{3, 1, 3}},
+		{"3:2 1:1 0:0", []int{3, 3, 1}},
+		{"  ", []int{}},
+	} {
+		doc, err := ParseDoc(c.s)
+		if err != nil {
+			t.Errorf("ParseDoc(%q): %v", c.s, err)
+		} else if !reflect.DeepEqual(doc, c.doc) {
+			t.Errorf("ParseDoc(%q) = %v, want %v", c.s, doc, c.doc)
+		}
+	}
+}
+
+func TestParseDocErrors(t *testing.T) {
+	for _, s := range []string{
+		"1 x",
+		"-1",
+		"1:-2",
+		"1:",
+		fmt.Sprintf("1:%d", MaxWordCount+1),
+		fmt.Sprintf("1:%d", 1<<62),
+		strings.Repeat(fmt.Sprintf("1:%d ", MaxWordCount), MaxDocLength/MaxWordCount) + "2",
+	} {
+		if _, err := ParseDoc(s); err == nil {
+			t.Errorf("ParseDoc(%.40q) accepted", s)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"math/rand"
+	"sort"
+
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+)
+
+// TopicDriver is the contribution of a topic to a score or to a score margin
+type TopicDriver struct {
+	Topic        int     `json:"topic"`
+	Contribution float64 `json:"contribution"`
+}
+
+// WordDriver is the contribution of the tokens of a word assigned to a topic in a document,
+// Count is their average number over the posterior samples, Doc tells the document of a pair ("a" or "b")
+type WordDriver struct {
+	Doc          string  `json:"doc,omitempty"`
+	Word         int     `json:"word"`
+	Topic        int     `json:"topic"`
+	Count        float64 `json:"count"`
+	LogPhi       float64 `json:"log_phi"`
+	Contribution float64 `json:"contribution"`
+}
+
+// Explanation decomposes the score nu·theta of a document, or the margin nu·(theta_A - theta_B)
+// of a pair, into the contributions of the topics, and the same value into the words and the prior:
+// theta_k = (E[n_k] + beta_k) / (n + Σbeta) averages the topic counts over the posterior samples,
+// so a token assigned to topic k adds nu_k / (n + Σbeta) per sample it is assigned in and the
+// prior adds nu·beta / (n + Σbeta), the words and the prior add up to Value
+type Explanation struct {
+	Value  float64       `json:"value"`
+	Prob   float64       `json:"prob,omitempty"`
+	Prior  float64       `json:"prior"`
+	Topics []TopicDriver `json:"topics"`
+	Words  []WordDriver  `json:"words"`
+}
+
+// Posterior keeps the topic proportions of a document together with the average
+// number of its tokens of every word assigned to every topic over the same samples
+type Posterior struct {
+	Doc   []int
+	Theta []float64
+	words map[[2]int]float64
+}
+
+// InferDocExplained infers the posterior of the document for an explanation, the topic
+// proportions equal those of InferDocPosterior with the same random stream
+func (p *Predictor) InferDocExplained(doc []int, s *InferSettings, rng *rand.Rand) *Posterior {
+	ds := p.newDocSampler(doc, rng)
+	ds.anneal(s)
+	ds.wordSum = make(map[[2]int]float64)
+	theta := ds.theta(s)
+	if s.NumSamples <= 0 {
+		// the proportions come from the final assignment
+		ds.accumulate()
+	} else {
+		for key := range ds.wordSum {
+			ds.wordSum[key] /= float64(s.NumSamples)
+		}
+	}
+	return &Posterior{Doc: doc, Theta: theta, words: ds.wordSum}
+}
+
+// wordDrivers returns the contributions of the document words by topic, sign is the sign
+// of the document in the explanation
+func (p *Predictor) wordDrivers(name string, post *Posterior, sign float64) []WordDriver {
+	result := make([]WordDriver, 0, len(post.words))
+	unit := sign / (float64(len(post.Doc)) + p.betaSum)
+	for key, count := range post.words {
+		w, k := key[0], key[1]
+		result = append(result, WordDriver{Doc: name, Word: w, Topic: k, Count: count,
+			LogPhi: p.logPhi[k][w], Contribution: unit * p.nu[k] * count})
+	}
+	// the map order is random, the drivers are sorted stably afterwards
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Word != result[b].Word {
+			return result[a].Word < result[b].Word
+		}
+		return result[a].Topic < result[b].Topic
+	})
+	return result
+}
+
+// prior returns the contribution of the prior to the score of the document
+func (p *Predictor) prior(post *Posterior) float64 {
+	return p.nuBeta / (float64(len(post.Doc)) + p.betaSum)
+}
+
+// sortDrivers sorts the drivers from the most positive to the most negative, the words of equal
+// contributions are ordered by how characteristic they are of their topic, the most on the outside
+func (e *Explanation) sortDrivers() {
+	sort.SliceStable(e.Topics, func(a, b int) bool { return e.Topics[a].Contribution > e.Topics[b].Contribution })
+	sort.SliceStable(e.Words, func(a, b int) bool {
+		x, y := e.Words[a], e.Words[b]
+		if x.Contribution != y.Contribution {
+			return x.Contribution > y.Contribution
+		}
+		if x.Contribution < 0 {
+			return x.LogPhi < y.LogPhi
+		}
+		return x.LogPhi > y.LogPhi
+	})
+}
+
+// ExplainDoc decomposes the score of the document with the posterior post
+func (p *Predictor) ExplainDoc(post *Posterior) *Explanation {
+	e := &Explanation{Topics: make([]TopicDriver, p.k), Prior: p.prior(post)}
+	for k := 0; k < p.k; k++ {
+		e.Topics[k] = TopicDriver{k, p.nu[k] * post.Theta[k]}
+		e.Value += e.Topics[k].Contribution
+	}
+	e.Words = p.wordDrivers("", post, 1)
+	e.sortDrivers()
+	return e
+}
+
+// ExplainPair decomposes the margin of the document A over the document B, positive drivers favour A
+func (p *Predictor) ExplainPair(a, b *Posterior) *Explanation {
+	e := &Explanation{Topics: make([]TopicDriver, p.k), Prior: p.prior(a) - p.prior(b)}
+	for k := 0; k < p.k; k++ {
+		e.Topics[k] = TopicDriver{k, p.nu[k] * (a.Theta[k] - b.Theta[k])}
+		e.Value += e.Topics[k].Contribution
+	}
+	e.Prob = umath.Sigmoid(e.Value)
+	e.Words = append(p.wordDrivers("a", a, 1), p.wordDrivers("b", b, -1)...)
+	e.sortDrivers()
+	return e
+}
+
+// Top keeps only the n most positive and the n most negative drivers of the explanation
+func (e *Explanation) Top(n int) *Explanation {
+	result := &Explanation{Value: e.Value, Prob: e.Prob, Prior: e.Prior, Topics: []TopicDriver{}, Words: []WordDriver{}}
+	for i, t := range e.Topics {
+		if t.Contribution > 0 && i < n || t.Contribution < 0 && i >= len(e.Topics)-n {
+			result.Topics = append(result.Topics, t)
+		}
+	}
+	for i, w := range e.Words {
+		if w.Contribution > 0 && i < n || w.Contribution < 0 && i >= len(e.Words)-n {
+			result.Words = append(result.Words, w)
+		}
+	}
+	return result
+}
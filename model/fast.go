@@ -0,0 +1,80 @@
+package model
+
+import (
+	"math"
+
+	"github.com/gonum/floats"
+)
+
+// The fast scorer replaces the annealing of a document by a single pass over its words:
+// every word contributes its topic posterior p(k|w) ∝ beta_k phi_k(w) to the topic counts,
+// so the score nu·theta becomes a linear function of the word counts
+
+// wordTopics fills dist with the topic posterior p(k|w) of the word under the prior beta
+func (p *Predictor) wordTopics(dist []float64, w int) {
+	for k := 0; k < p.k; k++ {
+		dist[k] = math.Log(p.beta[k]) + p.logPhi[k][w]
+	}
+	norm := floats.LogSumExp(dist)
+	for k := range dist {
+		dist[k] = math.Exp(dist[k] - norm)
+	}
+}
+
+// fastWordScores computes the expected score Σ_k nu_k p(k|w) of every word
+func (p *Predictor) fastWordScores() []float64 {
+	scores := make([]float64, p.v)
+	dist := make([]float64, p.k)
+	for w := range scores {
+		p.wordTopics(dist, w)
+		scores[w] = floats.Dot(p.nu, dist)
+	}
+	return scores
+}
+
+// WordScore returns the expected score Σ_k nu_k p(k|w) of the word
+func (p *Predictor) WordScore(w int) float64 {
+	return p.wordScore[w]
+}
+
+// FastThetaDoc approximates the topic proportions of the document, smoothed by beta
+// as the posterior proportions, by the sum of the topic posteriors of its words
+func (p *Predictor) FastThetaDoc(doc []int) []float64 {
+	theta := make([]float64, p.k)
+	dist := make([]float64, p.k)
+	for _, w := range doc {
+		p.wordTopics(dist, w)
+		floats.Add(theta, dist)
+	}
+	floats.Add(theta, p.beta)
+	floats.Scale(1.0/(float64(len(doc))+p.betaSum), theta)
+	return theta
+}
+
+// FastScoreDoc approximates the score of the document in a time linear in its length,
+// it equals the score of FastThetaDoc
+func (p *Predictor) FastScoreDoc(doc []int) float64 {
+	result := p.nuBeta
+	for _, w := range doc {
+		result += p.wordScore[w]
+	}
+	return result / (float64(len(doc)) + p.betaSum)
+}
+
+// FastTheta approximates the topic proportions of the documents
+func (p *Predictor) FastTheta(data *Data) [][]float64 {
+	theta := make([][]float64, len(data.W))
+	for i, doc := range data.W {
+		theta[i] = p.FastThetaDoc(doc)
+	}
+	return theta
+}
+
+// FastScore approximates the scores of the documents
+func (p *Predictor) FastScore(data *Data) []float64 {
+	scores := make([]float64, len(data.W))
+	for i, doc := range data.W {
+		scores[i] = p.FastScoreDoc(doc)
+	}
+	return scores
+}
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+
+	"bitbucket.org/sitfoxfly/ranklda/parallel"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+	"github.com/gonum/floats"
+)
+
+// HeldOut keeps the estimated log-likelihoods of held-out documents together with
+// the numbers of words they are estimated on
+type HeldOut struct {
+	LogLik []float64
+	Words  []int
+}
+
+func newHeldOut(n int) *HeldOut {
+	return &HeldOut{LogLik: make([]float64, n), Words: make([]int, n)}
+}
+
+// LogLikPerWord returns the total log-likelihood divided by the total number of words,
+// the documents without words add nothing
+func (h *HeldOut) LogLikPerWord() float64 {
+	logLik := 0.0
+	words := 0
+	for i, l := range h.LogLik {
+		logLik += l
+		words += h.Words[i]
+	}
+	return logLik / float64(words)
+}
+
+// Perplexity returns the perplexity of the held-out documents, exp(-LogLikPerWord)
+func (h *HeldOut) Perplexity() float64 {
+	return math.Exp(-h.LogLikPerWord())
+}
+
+// docPhi returns the topic-word probabilities of the document words, one row per word
+func (p *Predictor) docPhi(doc []int) [][]float64 {
+	phi := make([][]float64, len(doc))
+	for i, w := range doc {
+		phi[i] = make([]float64, p.k)
+		for k := 0; k < p.k; k++ {
+			phi[i][k] = math.Exp(p.logPhi[k][w])
+		}
+	}
+	return phi
+}
+
+// mixtureLogLik returns the log-likelihood of the words given by their phi rows under the topic proportions
+func mixtureLogLik(phi [][]float64, theta []float64) float64 {
+	result := 0.0
+	for _, phiW := range phi {
+		result += math.Log(floats.Dot(phiW, theta))
+	}
+	return result
+}
+
+// CompletionLikelihood estimates the document-completion likelihood: the tokens of every document
+// are split in halves by a random permutation, the topic proportions are inferred from the first
+// half and the second half is evaluated under them; the documents too short to split get no words
+func (p *Predictor) CompletionLikelihood(data *Data, s *InferSettings) *HeldOut {
+	h := newHeldOut(len(data.W))
+	parallel.For(len(data.W), s.Threads, func(i int) {
+		doc := data.W[i]
+		if len(doc) < 2 {
+			return
+		}
+		// the tokens of a word:count pair are contiguous, so the halves must not be taken in order
+		rng := umath.NewRand(s.Seed, i)
+		perm := rng.Perm(len(doc))
+		half := (len(doc) + 1) / 2
+		observed := make([]int, half)
+		heldOut := make([]int, len(doc)-half)
+		for j, pos := range perm {
+			if j < half {
+				observed[j] = doc[pos]
+			} else {
+				heldOut[j-half] = doc[pos]
+			}
+		}
+		_, theta := p.InferDocPosterior(observed, s, rng)
+		h.LogLik[i] = mixtureLogLik(p.docPhi(heldOut), theta)
+		h.Words[i] = len(heldOut)
+	})
+	return h
+}
+
+// sampleTopic samples the topic of a word from phi_k(w) (counts_k + beta_k), dist is a buffer
+func (p *Predictor) sampleTopic(dist, phiW []float64, counts []int, rng *rand.Rand) int {
+	sum := 0.0
+	for k := range dist {
+		dist[k] = phiW[k] * (float64(counts[k]) + p.beta[k])
+		sum += dist[k]
+	}
+	u := rng.Float64() * sum
+	for k, d := range dist {
+		u -= d
+		if u < 0 {
+			return k
+		}
+	}
+	return p.k - 1
+}
+
+// LeftToRight estimates the likelihood of the documents by the left-to-right particle
+// algorithm of Wallach et al. (2009), every word is predicted from the topics of the
+// preceding words resampled by each of numParticles particles
+func (p *Predictor) LeftToRight(data *Data, numParticles int, s *InferSettings) *HeldOut {
+	h := newHeldOut(len(data.W))
+	parallel.For(len(data.W), s.Threads, func(i int) {
+		h.LogLik[i] = p.leftToRightDoc(data.W[i], numParticles, umath.NewRand(s.Seed, i))
+		h.Words[i] = len(data.W[i])
+	})
+	return h
+}
+
+func (p *Predictor) leftToRightDoc(doc []int, numParticles int, rng *rand.Rand) float64 {
+	n := len(doc)
+	phi := p.docPhi(doc)
+	z := make([][]int, numParticles)
+	counts := make([][]int, numParticles)
+	for r := range z {
+		z[r] = make([]int, n)
+		counts[r] = make([]int, p.k)
+	}
+	dist := make([]float64, p.k)
+	result := 0.0
+	for i := 0; i < n; i++ {
+		prob := 0.0
+		for r := 0; r < numParticles; r++ {
+			zr, cr := z[r], counts[r]
+			for j := 0; j < i; j++ {
+				cr[zr[j]]--
+				zr[j] = p.sampleTopic(dist, phi[j], cr, rng)
+				cr[zr[j]]++
+			}
+			for k := 0; k < p.k; k++ {
+				prob += phi[i][k] * (float64(cr[k]) + p.beta[k]) / (float64(i) + p.betaSum)
+			}
+			zr[i] = p.sampleTopic(dist, phi[i], cr, rng)
+			cr[zr[i]]++
+		}
+		result += math.Log(prob / float64(numParticles))
+	}
+	return result
+}
+
+// ImportanceSampling estimates the likelihood of the documents by averaging the likelihood
+// over numSamples topic proportions drawn from the prior Dirichlet(beta)
+func (p *Predictor) ImportanceSampling(data *Data, numSamples int, s *InferSettings) *HeldOut {
+	h := newHeldOut(len(data.W))
+	parallel.For(len(data.W), s.Threads, func(i int) {
+		rng := umath.NewRand(s.Seed, i)
+		phi := p.docPhi(data.W[i])
+		theta := make([]float64, p.k)
+		logLik := make([]float64, numSamples)
+		for j := range logLik {
+			umath.SampleDirichlet(theta, p.beta, rng)
+			logLik[j] = mixtureLogLik(phi, theta)
+		}
+		h.LogLik[i] = floats.LogSumExp(logLik) - math.Log(float64(numSamples))
+		h.Words[i] = len(data.W[i])
+	})
+	return h
+}
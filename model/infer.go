@@ -0,0 +1,195 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/parallel"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+	"github.com/gonum/floats"
+)
+
+// docSampler keeps the state of topic assignments of a single unseen document
+type docSampler struct {
+	*Predictor
+	doc         []int
+	local       []int
+	z           []int
+	nIndex      []int
+	cIndex      [][]int
+	rng         *rand.Rand
+	index       int
+	comparisons []*coI
+	sum         []float64
+	// the posterior sum of the tokens by word and topic, kept only for explanations
+	wordSum map[[2]int]float64
+}
+
+func (p *Predictor) newDocSampler(doc []int, rng *rand.Rand) *docSampler {
+	n := len(doc)
+	z := make([]int, n)
+	for i := 0; i < n; i++ {
+		z[i] = rng.Intn(p.k)
+	}
+	// the document counts are kept only for its own words, local maps positions to them
+	local := make([]int, n)
+	words := make(map[int]int)
+	for i, w := range doc {
+		if _, ok := words[w]; !ok {
+			words[w] = len(words)
+		}
+		local[i] = words[w]
+	}
+	cIndex := make([][]int, p.k)
+	for i := 0; i < p.k; i++ {
+		cIndex[i] = make([]int, len(words))
+	}
+	for i := range doc {
+		cIndex[z[i]][local[i]]++
+	}
+	return &docSampler{
+		Predictor: p,
+		doc:       doc,
+		local:     local,
+		z:         z,
+		nIndex:    ints.Count(z, p.k),
+		cIndex:    cIndex,
+		rng:       rng,
+		sum:       make([]float64, p.k),
+	}
+}
+
+// sweep makes a Metropolis pass over the document words at the temperature T,
+// the comparisons of the document, if any, contribute as in optimizeZ
+func (ds *docSampler) sweep(T float64) {
+	for i, w := range ds.doc {
+		curZ := ds.z[i]
+		newZ := ds.rng.Intn(ds.k)
+		if curZ == newZ {
+			continue
+		}
+		l := ds.local[i]
+		diff := math.Log(ds.beta[curZ]+float64(ds.nIndex[curZ]-1)) -
+			math.Log(ds.beta[newZ]+float64(ds.nIndex[newZ])) +
+			math.Log(ds.alpha+float64(ds.counts[curZ][w]+ds.cIndex[curZ][l]-1)) -
+			math.Log(ds.alpha+float64(ds.counts[newZ][w]+ds.cIndex[newZ][l])) +
+			math.Log(float64(ds.totals[newZ]+ds.nIndex[newZ])+ds.alphaSum) -
+			math.Log(float64(ds.totals[curZ]+ds.nIndex[curZ]-1)+ds.alphaSum)
+		for _, entry := range ds.comparisons {
+			delta := (ds.nu[newZ] - ds.nu[curZ]) / ds.eta(entry)
+			diff += umath.LogSigmoid(entry.eval) - umath.LogSigmoid(entry.eval+delta)
+		}
+		prob := ds.rng.Float64()
+		if diff <= 0.0 || prob < math.Exp(-diff/T) {
+			ds.z[i] = newZ
+			ds.nIndex[curZ]--
+			ds.nIndex[newZ]++
+			ds.cIndex[curZ][l]--
+			ds.cIndex[newZ][l]++
+			for _, entry := range ds.comparisons {
+				entry.eval += (ds.nu[newZ] - ds.nu[curZ]) / ds.eta(entry)
+			}
+		}
+	}
+}
+
+func (ds *docSampler) eta(entry *coI) float64 {
+	if entry.X == ds.index {
+		return entry.etaX
+	}
+	return entry.etaY
+}
+
+func (ds *docSampler) anneal(s *InferSettings) {
+	T := s.InitT
+	for iter := 0; iter < s.NumSAIter; iter++ {
+		ds.sweep(T)
+		T *= s.CoolingRate
+	}
+}
+
+func sampleLag(s *InferSettings) int {
+	if s.SampleLag < 1 {
+		return 1
+	}
+	return s.SampleLag
+}
+
+// accumulate adds the current topic counts to the posterior sum
+func (ds *docSampler) accumulate() {
+	for i, c := range ds.nIndex {
+		ds.sum[i] += float64(c)
+	}
+	if ds.wordSum != nil {
+		for i, w := range ds.doc {
+			ds.wordSum[[2]int{w, ds.z[i]}]++
+		}
+	}
+}
+
+// proportions returns the topic proportions smoothed by beta from the posterior sum
+// of numSamples samples, the final assignment is used if there are no samples
+func (ds *docSampler) proportions(numSamples int) []float64 {
+	theta := make([]float64, ds.k)
+	if numSamples <= 0 {
+		for i, c := range ds.nIndex {
+			theta[i] = float64(c)
+		}
+	} else {
+		copy(theta, ds.sum)
+		floats.Scale(1.0/float64(numSamples), theta)
+	}
+	floats.Add(theta, ds.beta)
+	floats.Scale(1.0/(float64(len(ds.doc))+ds.betaSum), theta)
+	return theta
+}
+
+// theta averages the topic proportions over the posterior samples taken after annealing
+func (ds *docSampler) theta(s *InferSettings) []float64 {
+	for sample := 0; sample < s.NumSamples; sample++ {
+		for iter := 0; iter < sampleLag(s); iter++ {
+			ds.sweep(1.0)
+		}
+		ds.accumulate()
+	}
+	return ds.proportions(s.NumSamples)
+}
+
+// Infer infers topic assigment for the unseen data, the documents are processed
+// by s.Threads workers with the same result as the serial run
+func (p *Predictor) Infer(data *Data, s *InferSettings) [][]int {
+	z := make([][]int, len(data.W))
+	parallel.For(len(data.W), s.Threads, func(i int) {
+		z[i] = p.InferDoc(data.W[i], s, umath.NewRand(s.Seed, i))
+	})
+	return z
+}
+
+// InferDoc infers topic assignments of the document using its own random stream
+func (p *Predictor) InferDoc(doc []int, s *InferSettings, rng *rand.Rand) []int {
+	ds := p.newDocSampler(doc, rng)
+	ds.anneal(s)
+	return ds.z
+}
+
+// InferPosterior infers topic assignments for the unseen data together with the
+// topic proportions smoothed by beta and averaged over the samples after annealing
+func (p *Predictor) InferPosterior(data *Data, s *InferSettings) ([][]int, [][]float64) {
+	n := len(data.W)
+	z := make([][]int, n)
+	theta := make([][]float64, n)
+	parallel.For(n, s.Threads, func(i int) {
+		z[i], theta[i] = p.InferDocPosterior(data.W[i], s, umath.NewRand(s.Seed, i))
+	})
+	return z, theta
+}
+
+// InferDocPosterior infers the final topic assignments and the posterior topic proportions of the document
+func (p *Predictor) InferDocPosterior(doc []int, s *InferSettings, rng *rand.Rand) ([]int, []float64) {
+	ds := p.newDocSampler(doc, rng)
+	ds.anneal(s)
+	z := make([]int, len(doc))
+	copy(z, ds.z)
+	return z, ds.theta(s)
+}
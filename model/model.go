@@ -3,6 +3,7 @@ package model
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"math/rand"
@@ -10,29 +11,44 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 
+	"bitbucket.org/sitfoxfly/ranklda/atomicfile"
 	"bitbucket.org/sitfoxfly/ranklda/ints"
 	"bitbucket.org/sitfoxfly/ranklda/umath"
-	"github.com/gonum/floats"
 )
 
 // Model is a structure to represet RankLDA model
 type Model struct {
-	data   *Data
-	k      int
-	alpha  float64
-	beta   []float64
-	logPhi [][]float64
-	z      [][]int
-	nu     []float64
-	sigma  float64
+	data     *Data
+	k        int
+	alpha    float64
+	beta     []float64
+	logPhi   [][]float64
+	z        [][]int
+	nu       []float64
+	sigma    float64
+	counts   [][]int
+	totals   []int
+	seed     int64
+	settings OptSettings
+	// sweep counts the sweeps over the topic assignments, every sweep draws new per-document streams
+	sweep int
+
+	// the predictor of the inference methods, built on first use and dropped by Optimize
+	predictorMu sync.Mutex
+	predictor   *Predictor
 }
 
 // InferSettings - inference settings
 type InferSettings struct {
+	Seed        int64
 	NumSAIter   int
 	InitT       float64
 	CoolingRate float64
+	NumSamples  int
+	SampleLag   int
+	Threads     int
 }
 
 // OptSettings - optimization settings
@@ -56,9 +72,14 @@ type InitSet struct {
 	Beta  float64
 }
 
+// ReadModelWithData reads RankLDA model together with its training data,
+// the topic counts are rebuilt from the data if the model file lacks them
 func ReadModelWithData(fn1, fn2 string) *Model {
 	m := ReadModel(fn1)
 	m.data = ReadData(fn2)
+	if m.counts == nil {
+		m.counts, m.totals = m.countTopics()
+	}
 	return m
 }
 
@@ -76,177 +97,140 @@ func lreadInts(s string) []int {
 	return result
 }
 
-// ReadModel reads RankLDA model from the file
+func lreadFloats(s string) []float64 {
+	sc := bufio.NewScanner(strings.NewReader(s))
+	sc.Split(bufio.ScanWords)
+	result := make([]float64, 0, 10)
+	for sc.Scan() {
+		if x, err := strconv.ParseFloat(sc.Text(), 64); err == nil {
+			result = append(result, x)
+		} else {
+			log.Fatal("ERROR: unable to parse model file ", err)
+		}
+	}
+	return result
+}
+
+// modelScanner reads the text model line by line and checks the line lengths
+type modelScanner struct {
+	*bufio.Scanner
+	line int
+}
+
+func (sc *modelScanner) next(what string) string {
+	sc.line++
+	if !sc.Scan() {
+		if sc.Err() != nil {
+			log.Fatalf("ERROR: unable to read %s (line %d): %v\n", what, sc.line, sc.Err())
+		}
+		log.Fatalf("ERROR: unexpected end of model file, %s is missing (line %d)\n", what, sc.line)
+	}
+	return sc.Text()
+}
+
+func (sc *modelScanner) floats(what string, n int) []float64 {
+	result := lreadFloats(sc.next(what))
+	if len(result) != n {
+		log.Fatalf("ERROR: %s has %d values instead of %d (line %d)\n", what, len(result), n, sc.line)
+	}
+	return result
+}
+
+func (sc *modelScanner) ints(what string, n int) []int {
+	result := lreadInts(sc.next(what))
+	if len(result) != n {
+		log.Fatalf("ERROR: %s has %d values instead of %d (line %d)\n", what, len(result), n, sc.line)
+	}
+	return result
+}
+
+// ReadModel reads RankLDA model from the file in either text or binary format
 func ReadModel(fn string) *Model {
 	f, err := os.Open(fn)
 	if err != nil {
 		log.Fatal("ERROR: unable to open file", err)
 	}
 	defer f.Close()
-	scanner := bufio.NewScanner(f)
+	r := bufio.NewReaderSize(f, 1024*1024)
+	if magic, err := r.Peek(len(binaryMagic)); err == nil && string(magic) == binaryMagic {
+		r.Discard(len(binaryMagic))
+		return readBinaryModel(r)
+	}
+	scanner := &modelScanner{Scanner: bufio.NewScanner(r)}
 	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-	var vocabSize int
+	scanner.Buffer(buf, 512*1024*1024)
 	model := &Model{}
-	scanner.Scan()
-	fmt.Sscanf(scanner.Text(), "%d %d", &model.k, &vocabSize)
-	model.beta = make([]float64, model.k)
-	{
-		scanner.Scan()
-		lineReader := strings.NewReader(scanner.Text())
-		for i := 0; i < model.k; i++ {
-			fmt.Fscanf(lineReader, "%f", &model.beta[i])
-		}
+	dims := scanner.ints("dimensions", 2)
+	model.k = dims[0]
+	vocabSize := dims[1]
+	model.beta = scanner.floats("beta", model.k)
+	// the line of alpha keeps sigma too, the older models have alpha only
+	hyper := lreadFloats(scanner.next("alpha"))
+	if len(hyper) != 1 && len(hyper) != 2 {
+		log.Fatalf("ERROR: alpha has %d values instead of 1 or 2 (line %d)\n", len(hyper), scanner.line)
+	}
+	model.alpha = hyper[0]
+	if len(hyper) == 2 {
+		model.sigma = hyper[1]
 	}
-	scanner.Scan()
-	fmt.Sscanf(scanner.Text(), "%f", &model.alpha)
 	model.logPhi = make([][]float64, model.k)
 	for i := 0; i < model.k; i++ {
-		scanner.Scan()
-		lineReader := strings.NewReader(scanner.Text())
-		model.logPhi[i] = make([]float64, vocabSize)
-		for j := 0; j < vocabSize; j++ {
-			fmt.Fscanf(lineReader, "%f", &model.logPhi[i][j])
-		}
+		model.logPhi[i] = scanner.floats(fmt.Sprintf("logPhi[%d]", i), vocabSize)
 	}
-	{
-		scanner.Scan()
-		lineReader := strings.NewReader(scanner.Text())
-		model.nu = make([]float64, model.k)
-		for i := 0; i < model.k; i++ {
-			fmt.Fscanf(lineReader, "%f", &model.nu[i])
-		}
-	}
-	var n int
-	scanner.Scan()
-	fmt.Sscanf(scanner.Text(), "%d", &n)
+	model.nu = scanner.floats("nu", model.k)
+	n := scanner.ints("number of documents", 1)[0]
 	model.z = make([][]int, n)
 	for i := 0; i < n; i++ {
-		scanner.Scan()
-		model.z[i] = lreadInts(scanner.Text())
+		model.z[i] = lreadInts(scanner.next(fmt.Sprintf("z[%d]", i)))
+	}
+	// topic counts are optional for the models saved without them
+	if scanner.Scan() && strings.TrimSpace(scanner.Text()) != "" {
+		scanner.line++
+		model.totals = lreadInts(scanner.Text())
+		if len(model.totals) != model.k {
+			log.Fatalf("ERROR: topic totals have %d values instead of %d (line %d)\n", len(model.totals), model.k, scanner.line)
+		}
+		model.counts = make([][]int, model.k)
+		for i := 0; i < model.k; i++ {
+			model.counts[i] = scanner.ints(fmt.Sprintf("counts[%d]", i), vocabSize)
+		}
 	}
 	return model
 }
 
-// Infer infers topic assigment for the unseen data
-func (model *Model) Infer(data *Data, s *InferSettings) [][]int {
-	n := len(data.W)
-	z := make([][]int, 0, n)
-	trainee := model.trainable()
-	for _, doc := range data.W {
-		zi := trainee.InferDoc(doc, s)
-		z = append(z, zi)
+func (model *Model) vocabSize() int {
+	if model.data != nil {
+		return model.data.V
 	}
-	return z
+	return len(model.logPhi[0])
 }
 
-func (model *Model) scoreDoc(w []int, z []int) float64 {
-	n := len(w)
-	zInd := ints.Count(z, model.k)
-	betaSum := floats.Sum(model.beta)
-	res := 0.0
-
-	res += umath.Lgamma(betaSum) - umath.Lgamma(float64(n)+betaSum)
+// countTopics rebuilds the topic-word counts from the training data
+func (model *Model) countTopics() ([][]int, []int) {
+	counts := make([][]int, model.k)
 	for i := 0; i < model.k; i++ {
-		res += umath.Lgamma(model.beta[i]+float64(zInd[i])) - umath.Lgamma(model.beta[i])
-	}
-
-	for i := 0; i < n; i++ {
-		res += model.logPhi[z[i]][w[i]]
-	}
-
-	return res
-}
-
-func (model *Model) scoreDoc2(w []int, z []int) float64 {
-	n := len(w)
-	//zInd := ints.Count(z, mod.k)
-	//betaSum := floats.Sum(mod.bs)
-	res := 0.0
-
-	//res += umath.Lgamma(betaSum) - umath.Lgamma(float64(n)+betaSum)
-	//for i := 0; i < mod.k; i++ {
-	//	res += umath.Lgamma(mod.bs[i]+float64(zInd[i])) - umath.Lgamma(mod.bs[i])
-	//}
-
-	for i := 0; i < n; i++ {
-		res += model.logPhi[z[i]][w[i]]
-	}
-
-	return res
-}
-
-func (model *Model) inferDoc(doc []int, s *InferSettings) []int {
-	n := len(doc)
-	z := make([]int, n)
-	for i := 0; i < n; i++ {
-		z[i] = rand.Intn(model.k)
+		counts[i] = make([]int, model.data.V)
 	}
-	zInd := ints.Count(z, model.k)
-	T := s.InitT
-	//fmt.Printf("OBJ = %g\n", mod.scoreDoc(doc, z))
-	for iter := 0; iter < s.NumSAIter; iter++ {
-		for i := 0; i < n; i++ {
-			curZ := z[i]
-			newZ := rand.Intn(model.k)
-			w := doc[i]
-			diff := math.Log(model.beta[curZ]+float64(zInd[curZ]-1)) - math.Log(model.beta[newZ]+float64(zInd[newZ])) + model.logPhi[curZ][w] - model.logPhi[newZ][w]
-			prob := rand.Float64()
-			if diff <= 0.0 || prob < math.Exp(-diff/T) {
-				z[i] = newZ
-				zInd[curZ]--
-				zInd[newZ]++
-			}
+	totals := make([]int, model.k)
+	for i, row := range model.z {
+		for j, z := range row {
+			counts[z][model.data.W[i][j]]++
+			totals[z]++
 		}
-		T *= s.CoolingRate
 	}
-	//fmt.Printf("OBJ = %g\n", mod.scoreDoc(doc, z))
-	//fmt.Println("=== DONE ===")
-	return z
-}
-
-// Perplexity computes point estimate perplexity on a set of documents
-func (model *Model) Perplexity(docs [][]int, z [][]int) float64 {
-	logProb := 0.0
-	normalizer := 0
-	trainee := model.trainable()
-	trainee.optimizePhi()
-	for i, doc := range docs {
-		logProb += trainee.scoreDoc(doc, z[i])
-		normalizer += len(doc)
-	}
-	return logProb / float64(normalizer)
+	return counts, totals
 }
 
-func (model *Model) Perplexity2(docs [][]int) float64 {
-	s := 10
-	logProb := 0.0
-	normalizer := 0
-	trainee := model.trainable()
-	trainee.optimizePhi()
-	for _, doc := range docs {
-		z := make([]int, len(doc))
-		cumLogProb := 0.0
-		for j := 0; j < s; j++ {
-			for k := 0; k < len(doc); k++ {
-				z[k] = rand.Intn(model.k)
-			}
-			cumLogProb += trainee.scoreDoc2(doc, z)
-		}
-		logProb += cumLogProb / float64(s)
-		normalizer += len(doc)
+// topicCounts returns the topic-word counts, they are rebuilt from the training data
+// if the model has none, nil is returned if there is no training data either
+func (model *Model) topicCounts() ([][]int, []int) {
+	if model.counts != nil {
+		return model.counts, model.totals
 	}
-	return logProb / float64(normalizer)
-}
-
-// Score computes the doc scores and builds pairwise comparison list
-func (model *Model) Score(z [][]int) []float64 {
-	scores := make([]float64, 0)
-	for _, zi := range z {
-		//fmt.Println(mod.nu, ints.Dist(ints.Count(zi, mod.k)), floats.Dot(mod.nu, ints.Dist(ints.Count(zi, mod.k))))
-		scores = append(scores, floats.Dot(model.nu, ints.Dist(ints.Count(zi, model.k))))
+	if model.data == nil {
+		return nil, nil
 	}
-	return scores
+	return model.countTopics()
 }
 
 // RandomModel builds a randomly initialized model for the data supplied
@@ -256,21 +240,25 @@ func RandomModel(data *Data, init *InitSet) *Model {
 	model.sigma = init.Sigma
 	model.alpha = init.Alpha
 	model.data = data
+	model.seed = init.Seed
 	model.z = make([][]int, len(data.W))
 	for i, doc := range data.W {
+		// the initial assignments of every document come from its own stream
+		rng := umath.NewRand(init.Seed, i)
 		n := len(doc)
 		model.z[i] = make([]int, n)
 		for j := 0; j < n; j++ {
-			model.z[i][j] = rand.Intn(model.k)
+			model.z[i][j] = rng.Intn(model.k)
 		}
 	}
 
+	rng := rand.New(rand.NewSource(init.Seed))
 	model.beta = make([]float64, model.k)
 	model.nu = make([]float64, model.k)
 	model.logPhi = make([][]float64, model.k)
 	for i := 0; i < model.k; i++ {
 		model.beta[i] = init.Beta
-		model.nu[i] = rand.NormFloat64() * model.sigma
+		model.nu[i] = rng.NormFloat64() * model.sigma
 		model.logPhi[i] = make([]float64, data.V)
 		for j := 0; j < data.V; j++ {
 			model.logPhi[i][j] = -math.Log(float64(data.V))
@@ -286,6 +274,7 @@ func AssignedModel(data *Data, init *InitSet, assignments [][]ints.Pair) *Model
 	model.sigma = init.Sigma
 	model.alpha = init.Alpha
 	model.data = data
+	model.seed = init.Seed
 	model.z = make([][]int, len(data.W))
 	for i, v := range data.W {
 		docAssign := assignments[i]
@@ -300,12 +289,13 @@ func AssignedModel(data *Data, init *InitSet, assignments [][]ints.Pair) *Model
 		}
 	}
 
+	rng := rand.New(rand.NewSource(init.Seed))
 	model.beta = make([]float64, model.k)
 	model.nu = make([]float64, model.k)
 	model.logPhi = make([][]float64, model.k)
 	for i := 0; i < model.k; i++ {
 		model.beta[i] = init.Beta
-		model.nu[i] = rand.NormFloat64() * model.sigma
+		model.nu[i] = rng.NormFloat64() * model.sigma
 		model.logPhi[i] = make([]float64, data.V)
 		for j := 0; j < data.V; j++ {
 			model.logPhi[i][j] = -math.Log(float64(data.V))
@@ -314,14 +304,21 @@ func AssignedModel(data *Data, init *InitSet, assignments [][]ints.Pair) *Model
 	return model
 }
 
-// Optimize optimizes the RankLDA model with Variational Approximation Algorithm
-func (model *Model) Optimize(s *OptSettings, dir string) {
+// Optimize optimizes the RankLDA model with Variational Approximation Algorithm,
+// the per-iteration likelihood and model snapshots are written to dir if it is given
+func (model *Model) Optimize(s *OptSettings, dir string) error {
+	model.settings = *s
+	model.predictorMu.Lock()
+	model.predictor = nil
+	model.predictorMu.Unlock()
 
 	var lhLog *os.File
 	if dir != "" {
-		if lhLog, err := os.Open(path.Join(dir, "likelihood.txt")); err != nil {
-			defer lhLog.Close()
+		var err error
+		if lhLog, err = os.Create(path.Join(dir, "likelihood.txt")); err != nil {
+			return err
 		}
+		defer lhLog.Close()
 	}
 
 	if s.BurnInIter > 0 {
@@ -347,14 +344,22 @@ func (model *Model) Optimize(s *OptSettings, dir string) {
 		likelihood := trainable.logLikelihood()
 		log.Printf("likelihood = %f\n", likelihood)
 		if lhLog != nil {
-			lhLog.WriteString(fmt.Sprintln(likelihood))
+			if _, err := lhLog.WriteString(fmt.Sprintln(likelihood)); err != nil {
+				return err
+			}
 		}
 		if dir != "" {
-			trainable.Save(path.Join(dir, fmt.Sprintf("%02d-model.txt", i)))
+			if err := trainable.Save(path.Join(dir, fmt.Sprintf("%02d-model.txt", i))); err != nil {
+				return err
+			}
 		}
 		T *= s.GlobalCRate
 	}
 	trainable.optimizeNu()
+	if lhLog != nil {
+		return lhLog.Sync()
+	}
+	return nil
 }
 
 func (model *Model) plainTrainable() *trainableModel {
@@ -379,6 +384,7 @@ func (model *Model) plainTrainable() *trainableModel {
 		comparisonIndex[i] = make([]*coI, 0)
 	}
 
+	model.counts, model.totals = cIndex, zIndex
 	return &trainableModel{model, nIndex, cIndex, zIndex, comparisonIndex}
 }
 
@@ -411,32 +417,32 @@ func (model *Model) trainable() *trainableModel {
 		comparisonIndex[comp.Y] = append(comparisonIndex[comp.Y], ref)
 	}
 
+	// the model keeps sharing the live topic counts while training
+	model.counts, model.totals = cIndex, zIndex
 	return &trainableModel{model, nIndex, cIndex, zIndex, comparisonIndex}
 }
 
-func (model *Model) Save(fn string) {
-	f, err := os.Create(fn)
-	if err != nil {
-		log.Fatal("ERROR: unable to save model file:", fn)
-	}
-	defer f.Close()
-
-	fmt.Fprintf(f, "%d %d\n", model.k, model.data.V)
-	for _, b := range model.beta {
-		fmt.Fprintf(f, "%f ", b)
+// writeFloats writes the line of values with the precision sufficient to restore them exactly
+func writeFloats(f io.Writer, xs []float64) {
+	for _, x := range xs {
+		fmt.Fprint(f, strconv.FormatFloat(x, 'g', -1, 64), " ")
 	}
 	fmt.Fprintln(f)
-	fmt.Fprintf(f, "%f\n", model.alpha)
+}
+
+// Save saves the model in the text format
+func (model *Model) Save(fn string) error {
+	return atomicfile.Write(fn, model.writeText)
+}
+
+func (model *Model) writeText(f io.Writer) error {
+	fmt.Fprintf(f, "%d %d\n", model.k, model.vocabSize())
+	writeFloats(f, model.beta)
+	writeFloats(f, []float64{model.alpha, model.sigma})
 	for _, row := range model.logPhi {
-		for _, logPhi := range row {
-			fmt.Fprintf(f, "%f ", logPhi)
-		}
-		fmt.Fprintln(f)
+		writeFloats(f, row)
 	}
-	for _, w := range model.nu {
-		fmt.Fprintf(f, "%f ", w)
-	}
-	fmt.Fprintln(f)
+	writeFloats(f, model.nu)
 	fmt.Fprintf(f, "%d\n", len(model.z))
 	for _, row := range model.z {
 		for _, z := range row {
@@ -444,19 +450,30 @@ func (model *Model) Save(fn string) {
 		}
 		fmt.Fprintln(f)
 	}
-}
-
-func (model *Model) SaveLDA(fn string) {
-	f, err := os.Create(fn)
-	if err != nil {
-		log.Fatal("ERROR: unbale to save LDA model", err)
-	}
-	defer f.Close()
-
-	for i := 0; i < model.k; i++ {
-		for j := 0; j < model.data.V; j++ {
-			fmt.Fprintf(f, "%.10f ", math.Exp(model.logPhi[i][j]))
+	if counts, totals := model.topicCounts(); counts != nil {
+		for _, total := range totals {
+			fmt.Fprintf(f, "%d ", total)
 		}
 		fmt.Fprintln(f)
+		for _, row := range counts {
+			for _, c := range row {
+				fmt.Fprintf(f, "%d ", c)
+			}
+			fmt.Fprintln(f)
+		}
 	}
+	return nil
+}
+
+// SaveLDA saves the topic-word distributions as a plain LDA matrix
+func (model *Model) SaveLDA(fn string) error {
+	return atomicfile.Write(fn, func(f io.Writer) error {
+		for i := 0; i < model.k; i++ {
+			for j := 0; j < model.vocabSize(); j++ {
+				fmt.Fprintf(f, "%.10f ", math.Exp(model.logPhi[i][j]))
+			}
+			fmt.Fprintln(f)
+		}
+		return nil
+	})
 }
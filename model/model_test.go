@@ -0,0 +1,94 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+)
+
+func TestTextRoundTrip(t *testing.T) {
+	model := testModel()
+	model.data.C = []ints.Pair{{X: 0, Y: 1}, {X: 3, Y: 1}, {X: 0, Y: 3}}
+	fn := filepath.Join(t.TempDir(), "model.txt")
+	if err := model.Save(fn); err != nil {
+		t.Fatal(err)
+	}
+	got := ReadModel(fn)
+	if got.k != model.k || got.alpha != model.alpha || got.sigma != model.sigma {
+		t.Errorf("k=%d alpha=%v sigma=%v, want k=%d alpha=%v sigma=%v", got.k, got.alpha, got.sigma, model.k, model.alpha, model.sigma)
+	}
+	if !reflect.DeepEqual(got.beta, model.beta) || !reflect.DeepEqual(got.nu, model.nu) || !reflect.DeepEqual(got.z, model.z) {
+		t.Errorf("beta, nu or z differ after the round trip")
+	}
+
+	// the precision of nu needs sigma, the reloaded model must agree with the original
+	got.data = model.data
+	want := model.NuPrecision()
+	precision := got.NuPrecision()
+	if want == nil || !reflect.DeepEqual(precision, want) {
+		t.Fatalf("NuPrecision = %v, want %v", precision, want)
+	}
+	for i, row := range precision {
+		for j, x := range row {
+			if math.IsInf(x, 0) || math.IsNaN(x) {
+				t.Errorf("precision[%d][%d] = %v", i, j, x)
+			}
+		}
+	}
+}
+
+func TestNuPrecisionWithoutSigma(t *testing.T) {
+	model := testModel()
+	model.sigma = 0
+	if precision := model.NuPrecision(); precision != nil {
+		t.Errorf("NuPrecision without sigma = %v, want nil", precision)
+	}
+}
+
+// writeData writes the data in the data file format of ReadData
+func writeData(t *testing.T, fn string, data *Data) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %d\n", len(data.W), len(data.C))
+	for _, doc := range data.W {
+		for _, w := range doc {
+			fmt.Fprintf(&b, "%d:1 ", w)
+		}
+		b.WriteString("\n")
+	}
+	for _, c := range data.C {
+		fmt.Fprintf(&b, "%d %d\n", c.X, c.Y)
+	}
+	if err := os.WriteFile(fn, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContinueTraining(t *testing.T) {
+	model := testModel()
+	model.data.C = []ints.Pair{{X: 0, Y: 1}, {X: 3, Y: 1}}
+	dir := t.TempDir()
+	modelFn, dataFn := filepath.Join(dir, "model.txt"), filepath.Join(dir, "data.txt")
+	if err := model.Save(modelFn); err != nil {
+		t.Fatal(err)
+	}
+	writeData(t, dataFn, model.data)
+
+	s := &OptSettings{NumIter: 2, NumSAIter: 1, InitT: 1, LocalCRate: 1, GlobalCRate: 1}
+	var z [][][]int
+	for run := 0; run < 2; run++ {
+		m := ReadModelWithData(modelFn, dataFn)
+		if err := m.Optimize(s, ""); err != nil {
+			t.Fatal(err)
+		}
+		z = append(z, m.z)
+	}
+	if !reflect.DeepEqual(z[0], z[1]) {
+		t.Errorf("continued training is not reproducible: %v != %v", z[0], z[1])
+	}
+}
@@ -0,0 +1,60 @@
+package model
+
+import "github.com/gonum/floats"
+
+// The accessors below return the slices owned by the model, they must not be modified
+
+// K returns the number of topics
+func (model *Model) K() int {
+	return model.k
+}
+
+// V returns the vocabulary size
+func (model *Model) V() int {
+	return model.vocabSize()
+}
+
+// Alpha returns the topic-word pseudocount
+func (model *Model) Alpha() float64 {
+	return model.alpha
+}
+
+// Sigma returns the Gaussian regularization of nu
+func (model *Model) Sigma() float64 {
+	return model.sigma
+}
+
+// Beta returns the document-topic prior
+func (model *Model) Beta() []float64 {
+	return model.beta
+}
+
+// Nu returns the topic weights of the comparison model
+func (model *Model) Nu() []float64 {
+	return model.nu
+}
+
+// LogPhi returns the log topic-word distributions
+func (model *Model) LogPhi() [][]float64 {
+	return model.logPhi
+}
+
+// Z returns the topic assignments of the training documents
+func (model *Model) Z() [][]int {
+	return model.z
+}
+
+// Theta converts topic assignments to the topic proportions smoothed by beta
+func (model *Model) Theta(z [][]int) [][]float64 {
+	betaSum := floats.Sum(model.beta)
+	theta := make([][]float64, len(z))
+	for i, zi := range z {
+		theta[i] = make([]float64, model.k)
+		copy(theta[i], model.beta)
+		for _, zij := range zi {
+			theta[i][zij]++
+		}
+		floats.Scale(1.0/(float64(len(zi))+betaSum), theta[i])
+	}
+	return theta
+}
@@ -0,0 +1,236 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+	"github.com/gonum/floats"
+)
+
+// Predictor is a trained model prepared for inference, it is never modified
+// after construction and is safe for concurrent use
+type Predictor struct {
+	k        int
+	v        int
+	alpha    float64
+	alphaSum float64
+	beta     []float64
+	betaSum  float64
+	nu       []float64
+	logPhi   [][]float64
+	counts   [][]int
+	totals   []int
+	z        [][]int
+
+	// the expected word scores and nu·beta of the fast scorer
+	wordScore []float64
+	nuBeta    float64
+}
+
+// NewPredictor prepares the model for inference, the topic counts come from
+// the model file or are rebuilt from the training data
+func NewPredictor(model *Model) (*Predictor, error) {
+	counts, totals := model.topicCounts()
+	if counts == nil {
+		return nil, errors.New("model has no topic counts, the training data is required")
+	}
+	p := &Predictor{k: model.k, v: model.vocabSize(), alpha: model.alpha}
+	p.alphaSum = p.alpha * float64(p.v)
+	p.beta = append([]float64(nil), model.beta...)
+	p.betaSum = floats.Sum(p.beta)
+	p.nu = append([]float64(nil), model.nu...)
+	p.totals = append([]int(nil), totals...)
+	p.counts = make([][]int, p.k)
+	p.logPhi = make([][]float64, p.k)
+	for i := 0; i < p.k; i++ {
+		p.counts[i] = append([]int(nil), counts[i]...)
+		// logPhi is recomputed from the counts at full precision
+		p.logPhi[i] = make([]float64, p.v)
+		z := math.Log(float64(p.totals[i]) + p.alphaSum)
+		for j := 0; j < p.v; j++ {
+			p.logPhi[i][j] = math.Log(float64(p.counts[i][j])+p.alpha) - z
+		}
+	}
+	p.z = make([][]int, len(model.z))
+	for i, zi := range model.z {
+		p.z[i] = append([]int(nil), zi...)
+	}
+	p.wordScore = p.fastWordScores()
+	p.nuBeta = floats.Dot(p.nu, p.beta)
+	return p, nil
+}
+
+// K returns the number of topics
+func (p *Predictor) K() int {
+	return p.k
+}
+
+// V returns the vocabulary size
+func (p *Predictor) V() int {
+	return p.v
+}
+
+func (p *Predictor) scoreDoc(w []int, z []int) float64 {
+	n := len(w)
+	zInd := ints.Count(z, p.k)
+	res := 0.0
+
+	res += umath.Lgamma(p.betaSum) - umath.Lgamma(float64(n)+p.betaSum)
+	for i := 0; i < p.k; i++ {
+		res += umath.Lgamma(p.beta[i]+float64(zInd[i])) - umath.Lgamma(p.beta[i])
+	}
+
+	for i := 0; i < n; i++ {
+		res += p.logPhi[z[i]][w[i]]
+	}
+
+	return res
+}
+
+func (p *Predictor) scoreDoc2(w []int, z []int) float64 {
+	res := 0.0
+	for i := range w {
+		res += p.logPhi[z[i]][w[i]]
+	}
+	return res
+}
+
+// Perplexity computes point estimate perplexity on a set of documents
+func (p *Predictor) Perplexity(docs [][]int, z [][]int) float64 {
+	logProb := 0.0
+	normalizer := 0
+	for i, doc := range docs {
+		logProb += p.scoreDoc(doc, z[i])
+		normalizer += len(doc)
+	}
+	return logProb / float64(normalizer)
+}
+
+// Perplexity2 computes perplexity averaged over random topic assignments,
+// every document uses its own random stream derived from the seed
+//
+// Deprecated: the average over uniform assignments is not an estimate of the held-out
+// likelihood, use CompletionLikelihood, LeftToRight or ImportanceSampling instead
+func (p *Predictor) Perplexity2(docs [][]int, seed int64) float64 {
+	s := 10
+	logProb := 0.0
+	normalizer := 0
+	for i, doc := range docs {
+		rng := umath.NewRand(seed, i)
+		z := make([]int, len(doc))
+		cumLogProb := 0.0
+		for j := 0; j < s; j++ {
+			for k := 0; k < len(doc); k++ {
+				z[k] = rng.Intn(p.k)
+			}
+			cumLogProb += p.scoreDoc2(doc, z)
+		}
+		logProb += cumLogProb / float64(s)
+		normalizer += len(doc)
+	}
+	return logProb / float64(normalizer)
+}
+
+// score computes the doc scores from their topic proportions under the topic weights nu
+func score(nu []float64, theta [][]float64) []float64 {
+	scores := make([]float64, 0, len(theta))
+	for _, thetai := range theta {
+		scores = append(scores, floats.Dot(nu, thetai))
+	}
+	return scores
+}
+
+// compareDocs returns the probability that the document with topic proportions a
+// beats the document with topic proportions b under the topic weights nu
+func compareDocs(nu, a, b []float64) float64 {
+	result := 0.0
+	for i, nui := range nu {
+		result += nui * (a[i] - b[i])
+	}
+	return umath.Sigmoid(result)
+}
+
+// Score computes the doc scores from their topic proportions
+func (p *Predictor) Score(theta [][]float64) []float64 {
+	return score(p.nu, theta)
+}
+
+// CompareDocs returns the probability that the document with topic proportions a
+// beats the document with topic proportions b
+func (p *Predictor) CompareDocs(a, b []float64) float64 {
+	return compareDocs(p.nu, a, b)
+}
+
+// The inference methods of Model delegate to a predictor built from the model on first use,
+// they fail when the model has no topic counts
+
+// Predictor returns the predictor of the model, it is built on first use and kept until Optimize
+func (model *Model) Predictor() *Predictor {
+	model.predictorMu.Lock()
+	defer model.predictorMu.Unlock()
+	if model.predictor == nil {
+		p, err := NewPredictor(model)
+		if err != nil {
+			log.Fatal("ERROR: ", err)
+		}
+		model.predictor = p
+	}
+	return model.predictor
+}
+
+// Infer infers topic assignments for new documents
+func (model *Model) Infer(data *Data, s *InferSettings) [][]int {
+	return model.Predictor().Infer(data, s)
+}
+
+// InferDoc infers topic assignments for a single document
+func (model *Model) InferDoc(doc []int, s *InferSettings, rng *rand.Rand) []int {
+	return model.Predictor().InferDoc(doc, s, rng)
+}
+
+// InferPosterior infers topic assignments and posterior topic proportions for new documents
+func (model *Model) InferPosterior(data *Data, s *InferSettings) ([][]int, [][]float64) {
+	return model.Predictor().InferPosterior(data, s)
+}
+
+// InferDocPosterior infers topic assignments and posterior topic proportions for a single document
+func (model *Model) InferDocPosterior(doc []int, s *InferSettings, rng *rand.Rand) ([]int, []float64) {
+	return model.Predictor().InferDocPosterior(doc, s, rng)
+}
+
+// InferTransductive infers the documents jointly with their comparisons against the training documents
+func (model *Model) InferTransductive(data *Data, against []Against, s *InferSettings) ([][]int, [][]float64) {
+	return model.Predictor().InferTransductive(data, against, s)
+}
+
+// ScoreUncertainty returns the credible intervals of the document scores
+func (model *Model) ScoreUncertainty(data *Data, s *InferSettings, u *UncertaintySettings, precision [][]float64) []ScoreInterval {
+	return model.Predictor().ScoreUncertainty(data, s, u, precision)
+}
+
+// Perplexity computes point estimate perplexity on a set of documents
+func (model *Model) Perplexity(docs [][]int, z [][]int) float64 {
+	return model.Predictor().Perplexity(docs, z)
+}
+
+// Perplexity2 computes perplexity averaged over random topic assignments
+//
+// Deprecated: use CompletionLikelihood, LeftToRight or ImportanceSampling of the predictor instead
+func (model *Model) Perplexity2(docs [][]int, seed int64) float64 {
+	return model.Predictor().Perplexity2(docs, seed)
+}
+
+// Score computes the doc scores from their topic proportions, it needs no topic counts
+func (model *Model) Score(theta [][]float64) []float64 {
+	return score(model.nu, theta)
+}
+
+// CompareDocs returns the probability that the document with topic proportions a
+// beats the document with topic proportions b, it needs no topic counts
+func (model *Model) CompareDocs(a, b []float64) float64 {
+	return compareDocs(model.nu, a, b)
+}
@@ -3,7 +3,6 @@ package model
 import (
 	"log"
 	"math"
-	"math/rand"
 
 	"bitbucket.org/sitfoxfly/ranklda/ints"
 	"bitbucket.org/sitfoxfly/ranklda/umath"
@@ -93,50 +92,6 @@ func (model *trainableModel) logLikelihood() float64 {
 	return result
 }
 
-func (model *trainableModel) InferDoc(doc []int, s *InferSettings) []int {
-	n := len(doc)
-	alphaSum := model.alpha * float64(model.data.V)
-	z := make([]int, n)
-	for i := 0; i < n; i++ {
-		z[i] = rand.Intn(model.k)
-	}
-	nIndex := ints.Count(z, model.k)
-	cIndex := make([][]int, model.k)
-	for i := 0; i < model.k; i++ {
-		cIndex[i] = make([]int, model.data.V)
-	}
-	for i, w := range doc {
-		cIndex[z[i]][w]++
-	}
-	T := s.InitT
-	for iter := 0; iter < s.NumSAIter; iter++ {
-		for i := 0; i < n; i++ {
-			curZ := z[i]
-			newZ := rand.Intn(model.k)
-			if curZ == newZ {
-				continue
-			}
-			w := doc[i]
-			diff := math.Log(model.beta[curZ]+float64(nIndex[curZ]-1)) -
-				math.Log(model.beta[newZ]+float64(nIndex[newZ])) +
-				math.Log(model.alpha+float64(model.cIndex[curZ][w]+cIndex[curZ][w]-1)) -
-				math.Log(model.alpha+float64(model.cIndex[newZ][w]+cIndex[newZ][w])) +
-				math.Log(float64(model.zIndex[newZ]+nIndex[newZ])+alphaSum) -
-				math.Log(float64(model.zIndex[curZ]+nIndex[curZ]-1)+alphaSum)
-			prob := rand.Float64()
-			if diff <= 0.0 || prob < math.Exp(-diff/T) {
-				z[i] = newZ
-				nIndex[curZ]--
-				nIndex[newZ]++
-				cIndex[curZ][w]--
-				cIndex[newZ][w]++
-			}
-		}
-		T *= s.CoolingRate
-	}
-	return z
-}
-
 func (model *trainableModel) nuObjEval(nu []float64) float64 {
 	result := 0.0
 	for _, comp := range model.data.C {
@@ -315,13 +270,17 @@ func (model *trainableModel) optimizeZ(numIter int, initT, cRate, dropRate float
 
 	alphaSum := model.alpha * float64(model.data.V)
 
+	// the streams of the sweep follow the streams of the initial assignments and of the earlier sweeps,
+	// so the proposals of a document do not depend on the other documents
+	model.sweep++
 	for i := 0; i < model.data.N; i++ {
+		rng := umath.NewRand(model.seed, model.sweep*model.data.N+i)
 		n := len(model.data.W[i])
 		T := initT
 		//for k := 0; k < numIter; k++ {
 		for j := 0; j < n; j++ {
 			curZ := model.z[i][j]
-			newZ := rand.Intn(model.k)
+			newZ := rng.Intn(model.k)
 			if curZ == newZ {
 				continue
 			}
@@ -334,7 +293,7 @@ func (model *trainableModel) optimizeZ(numIter int, initT, cRate, dropRate float
 				math.Log(float64(model.zIndex[curZ]-1)+alphaSum)
 
 			for _, entry := range model.comparisonIndex[i] {
-				if rand.Float64() < dropRate {
+				if rng.Float64() < dropRate {
 					continue
 				}
 				var eta float64
@@ -347,7 +306,7 @@ func (model *trainableModel) optimizeZ(numIter int, initT, cRate, dropRate float
 				eval += umath.LogSigmoid(entry.eval) - umath.LogSigmoid(entry.eval+delta)
 			}
 
-			prob := rand.Float64()
+			prob := rng.Float64()
 			if eval <= 0.0 || prob < math.Exp(-eval/T) {
 				model.z[i][j] = newZ
 				model.nIndex[i][curZ]--
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+)
+
+// Against is a comparison of an unseen document with a training document
+type Against struct {
+	Doc   int
+	Train int
+	Wins  bool
+}
+
+// InferTransductive infers topic assignments and proportions of the unseen documents
+// taking into account the comparisons among them (data.C) and against the training
+// documents, phi and nu stay fixed; the documents are annealed and sampled jointly
+func (p *Predictor) InferTransductive(data *Data, against []Against, s *InferSettings) ([][]int, [][]float64) {
+	samplers := make([]*docSampler, data.N)
+	for i, doc := range data.W {
+		samplers[i] = p.newDocSampler(doc, umath.NewRand(s.Seed, i))
+		samplers[i].index = i
+	}
+
+	for _, comp := range data.C {
+		x, y := samplers[comp.X], samplers[comp.Y]
+		xLength, yLength := len(x.doc), len(y.doc)
+		if xLength == 0 || yLength == 0 {
+			continue
+		}
+		ref := &coI{comp.X, float64(xLength), -float64(yLength), umath.Anxmany(p.nu, x.nIndex, y.nIndex, xLength, yLength)}
+		x.comparisons = append(x.comparisons, ref)
+		y.comparisons = append(y.comparisons, ref)
+	}
+	for _, comp := range against {
+		ds := samplers[comp.Doc]
+		length, trainLength := len(ds.doc), len(p.z[comp.Train])
+		if length == 0 || trainLength == 0 {
+			continue
+		}
+		// the training side is fixed, so the entry refers to the unseen document only
+		trainIndex := ints.Count(p.z[comp.Train], p.k)
+		var ref *coI
+		if comp.Wins {
+			ref = &coI{comp.Doc, float64(length), 0, umath.Anxmany(p.nu, ds.nIndex, trainIndex, length, trainLength)}
+		} else {
+			ref = &coI{-1, 0, -float64(length), umath.Anxmany(p.nu, trainIndex, ds.nIndex, trainLength, length)}
+		}
+		ds.comparisons = append(ds.comparisons, ref)
+	}
+
+	T := s.InitT
+	for iter := 0; iter < s.NumSAIter; iter++ {
+		for _, ds := range samplers {
+			ds.sweep(T)
+		}
+		T *= s.CoolingRate
+	}
+	for sample := 0; sample < s.NumSamples; sample++ {
+		for iter := 0; iter < sampleLag(s); iter++ {
+			for _, ds := range samplers {
+				ds.sweep(1.0)
+			}
+		}
+		for _, ds := range samplers {
+			ds.accumulate()
+		}
+	}
+
+	z := make([][]int, data.N)
+	theta := make([][]float64, data.N)
+	for i, ds := range samplers {
+		z[i] = make([]int, len(ds.doc))
+		copy(z[i], ds.z)
+		theta[i] = ds.proportions(s.NumSamples)
+	}
+	return z, theta
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+
+	"bitbucket.org/sitfoxfly/ranklda/ints"
+	"bitbucket.org/sitfoxfly/ranklda/parallel"
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+	"github.com/gonum/floats"
+)
+
+// UncertaintySettings - settings of the score uncertainty estimation
+type UncertaintySettings struct {
+	NumChains int
+	NumDraws  int
+	Level     float64
+}
+
+// ScoreInterval describes the distribution of a document score
+type ScoreInterval struct {
+	Mean  float64 `json:"mean"`
+	Std   float64 `json:"std"`
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+}
+
+// NuPrecision returns the Cholesky factor of the Hessian of the negative log-posterior
+// of nu at its current value (Laplace approximation), the training data and the prior
+// variance sigma are required, nil is returned without them
+func (model *Model) NuPrecision() [][]float64 {
+	if model.data == nil || model.sigma <= 0 {
+		return nil
+	}
+	hess := make([][]float64, model.k)
+	for i := range hess {
+		hess[i] = make([]float64, model.k)
+		hess[i][i] = 1.0 / model.sigma
+	}
+	d := make([]float64, model.k)
+	for _, comp := range model.data.C {
+		nX := ints.Count(model.z[comp.X], model.k)
+		nY := ints.Count(model.z[comp.Y], model.k)
+		xLength := len(model.data.W[comp.X])
+		yLength := len(model.data.W[comp.Y])
+		sigmoid := umath.Sigmoid(umath.Anxmany(model.nu, nX, nY, xLength, yLength))
+		weight := sigmoid * (1.0 - sigmoid)
+		for i := 0; i < model.k; i++ {
+			d[i] = float64(nX[i])/float64(xLength) - float64(nY[i])/float64(yLength)
+		}
+		for i := 0; i < model.k; i++ {
+			for j := 0; j < model.k; j++ {
+				hess[i][j] += weight * d[i] * d[j]
+			}
+		}
+	}
+	l, err := umath.Cholesky(hess)
+	if err != nil {
+		log.Println("WARNING: Laplace approximation of nu failed:", err)
+		return nil
+	}
+	return l
+}
+
+// ScoreUncertainty estimates the score distributions of the unseen documents combining
+// the variability of topic assignments over independent chains with the posterior
+// uncertainty of nu given by its Cholesky precision factor (nil to keep nu fixed)
+func (p *Predictor) ScoreUncertainty(data *Data, s *InferSettings, u *UncertaintySettings, precision [][]float64) []ScoreInterval {
+	result := make([]ScoreInterval, len(data.W))
+	parallel.For(len(data.W), s.Threads, func(i int) {
+		thetas := make([][]float64, u.NumChains)
+		for c := 0; c < u.NumChains; c++ {
+			// chain 0 repeats the stream of InferPosterior
+			_, thetas[c] = p.InferDocPosterior(data.W[i], s, umath.NewRand(s.Seed+int64(c), i))
+		}
+		// the draws use the stream next to the chains
+		result[i] = p.scoreInterval(thetas, u, precision, umath.NewRand(s.Seed+int64(u.NumChains), i))
+	})
+	return result
+}
+
+func (p *Predictor) scoreInterval(thetas [][]float64, u *UncertaintySettings, precision [][]float64, rng *rand.Rand) ScoreInterval {
+	draws := make([]float64, u.NumDraws)
+	eps := make([]float64, p.k)
+	for d := range draws {
+		theta := thetas[rng.Intn(len(thetas))]
+		score := floats.Dot(p.nu, theta)
+		if precision != nil {
+			for i := range eps {
+				eps[i] = rng.NormFloat64()
+			}
+			score += floats.Dot(umath.SolveLowerT(precision, eps), theta)
+		}
+		draws[d] = score
+	}
+	sort.Float64s(draws)
+
+	mean := floats.Sum(draws) / float64(len(draws))
+	variance := 0.0
+	for _, x := range draws {
+		variance += (x - mean) * (x - mean)
+	}
+	if len(draws) > 1 {
+		variance /= float64(len(draws) - 1)
+	}
+	return ScoreInterval{
+		Mean:  mean,
+		Std:   math.Sqrt(variance),
+		Lower: quantile(draws, (1.0-u.Level)/2.0),
+		Upper: quantile(draws, (1.0+u.Level)/2.0),
+	}
+}
+
+// quantile returns the linearly interpolated quantile of the sorted values
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	pos := q * float64(len(sorted)-1)
+	i := int(math.Floor(pos))
+	if i >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(i)
+	return sorted[i]*(1-frac) + sorted[i+1]*frac
+}
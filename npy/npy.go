@@ -0,0 +1,128 @@
+package npy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const magic = "\x93NUMPY"
+
+func writeHeader(w io.Writer, descr string, shape []int) error {
+	dims := make([]string, len(shape))
+	for i, d := range shape {
+		dims[i] = fmt.Sprint(d)
+	}
+	tuple := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		tuple += ","
+	}
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, tuple)
+	// magic, version and header length take 10 bytes, the total must be aligned to 64
+	padding := 64 - (10+len(header)+1)%64
+	if padding == 64 {
+		padding = 0
+	}
+	header += strings.Repeat(" ", padding) + "\n"
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(magic)
+	buf.Write([]byte{1, 0})
+	binary.Write(buf, binary.LittleEndian, uint16(len(header)))
+	buf.WriteString(header)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func size(shape []int) int {
+	n := 1
+	for _, d := range shape {
+		n *= d
+	}
+	return n
+}
+
+// WriteFloat64s writes the row-major float64 array of the given shape in NPY format
+func WriteFloat64s(w io.Writer, shape []int, data []float64) error {
+	if size(shape) != len(data) {
+		return fmt.Errorf("npy: shape %v does not match %d values", shape, len(data))
+	}
+	if err := writeHeader(w, "<f8", shape); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, data)
+}
+
+// WriteInt32s writes the row-major int32 array of the given shape in NPY format
+func WriteInt32s(w io.Writer, shape []int, data []int32) error {
+	if size(shape) != len(data) {
+		return fmt.Errorf("npy: shape %v does not match %d values", shape, len(data))
+	}
+	if err := writeHeader(w, "<i4", shape); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, data)
+}
+
+// WriteInt64s writes the row-major int64 array of the given shape in NPY format
+func WriteInt64s(w io.Writer, shape []int, data []int64) error {
+	if size(shape) != len(data) {
+		return fmt.Errorf("npy: shape %v does not match %d values", shape, len(data))
+	}
+	if err := writeHeader(w, "<i8", shape); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, data)
+}
+
+// WriteStrings writes the strings as a fixed-width unicode array in NPY format
+func WriteStrings(w io.Writer, data []string) error {
+	width := 1
+	for _, s := range data {
+		if n := utf8.RuneCountInString(s); n > width {
+			width = n
+		}
+	}
+	if err := writeHeader(w, fmt.Sprintf("<U%d", width), []int{len(data)}); err != nil {
+		return err
+	}
+	row := make([]uint32, width)
+	for _, s := range data {
+		i := 0
+		for _, r := range s {
+			row[i] = uint32(r)
+			i++
+		}
+		for ; i < width; i++ {
+			row[i] = 0
+		}
+		if err := binary.Write(w, binary.LittleEndian, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Zip writes several arrays into a single NPZ archive
+type Zip struct {
+	zw *zip.Writer
+}
+
+// NewZip starts a new NPZ archive
+func NewZip(w io.Writer) *Zip {
+	return &Zip{zip.NewWriter(w)}
+}
+
+// Create starts the array with the given name in the archive
+func (z *Zip) Create(name string) (io.Writer, error) {
+	return z.zw.Create(name + ".npy")
+}
+
+// Close finishes the archive
+func (z *Zip) Close() error {
+	return z.zw.Close()
+}
@@ -0,0 +1,137 @@
+package npy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// readHeader checks the preamble of an NPY array and returns its header and the data after it
+func readHeader(t *testing.T, b []byte) (string, []byte) {
+	if !bytes.HasPrefix(b, []byte(magic)) || b[6] != 1 || b[7] != 0 {
+		t.Fatalf("bad preamble % x", b[:8])
+	}
+	n := int(binary.LittleEndian.Uint16(b[8:10]))
+	if (10+n)%64 != 0 {
+		t.Errorf("data offset %d is not aligned to 64", 10+n)
+	}
+	header := string(b[10 : 10+n])
+	if !strings.HasSuffix(header, "\n") {
+		t.Errorf("header %q does not end with a newline", header)
+	}
+	return strings.TrimRight(header, " \n"), b[10+n:]
+}
+
+func TestWriteFloat64s(t *testing.T) {
+	buf := &bytes.Buffer{}
+	data := []float64{1, -2.5, 3, 0, 1e-300, 6}
+	if err := WriteFloat64s(buf, []int{2, 3}, data); err != nil {
+		t.Fatal(err)
+	}
+	header, body := readHeader(t, buf.Bytes())
+	if want := "{'descr': '<f8', 'fortran_order': False, 'shape': (2, 3), }"; header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	got := make([]float64, len(data))
+	if err := binary.Read(bytes.NewReader(body), binary.LittleEndian, got); err != nil {
+		t.Fatal(err)
+	}
+	for i := range data {
+		if got[i] != data[i] {
+			t.Errorf("value %d = %v, want %v", i, got[i], data[i])
+		}
+	}
+	if len(body) != 8*len(data) {
+		t.Errorf("%d data bytes, want %d", len(body), 8*len(data))
+	}
+}
+
+func TestWriteInt32s(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteInt32s(buf, []int{3}, []int32{7, -1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	header, body := readHeader(t, buf.Bytes())
+	if want := "{'descr': '<i4', 'fortran_order': False, 'shape': (3,), }"; header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	want := []byte{7, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}
+	if !bytes.Equal(body, want) {
+		t.Errorf("data = % x, want % x", body, want)
+	}
+}
+
+func TestWriteInt64s(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteInt64s(buf, []int{2}, []int64{1 << 40, -2}); err != nil {
+		t.Fatal(err)
+	}
+	header, body := readHeader(t, buf.Bytes())
+	if want := "{'descr': '<i8', 'fortran_order': False, 'shape': (2,), }"; header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	got := make([]int64, 2)
+	if err := binary.Read(bytes.NewReader(body), binary.LittleEndian, got); err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 1<<40 || got[1] != -2 {
+		t.Errorf("data = %v", got)
+	}
+}
+
+func TestWriteShapeMismatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteFloat64s(buf, []int{2, 2}, []float64{1, 2, 3}); err == nil {
+		t.Error("expected a shape mismatch error")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes written on error", buf.Len())
+	}
+}
+
+func TestWriteStrings(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteStrings(buf, []string{"ab", "жук", ""}); err != nil {
+		t.Fatal(err)
+	}
+	header, body := readHeader(t, buf.Bytes())
+	if want := "{'descr': '<U3', 'fortran_order': False, 'shape': (3,), }"; header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	got := make([]uint32, 9)
+	if err := binary.Read(bytes.NewReader(body), binary.LittleEndian, got); err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{'a', 'b', 0, 'ж', 'у', 'к', 0, 0, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("code point %d = %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestZip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	z := NewZip(buf)
+	for _, name := range []string{"nu", "beta"} {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFloat64s(w, []int{2}, []float64{1, 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != 2 || r.File[0].Name != "nu.npy" || r.File[1].Name != "beta.npy" {
+		t.Errorf("unexpected archive entries %v", r.File)
+	}
+}
@@ -0,0 +1,33 @@
+package parallel
+
+import "sync"
+
+// For calls f for every index in [0, n) using a pool of the given number of workers,
+// the indices are handed out in order and f must be safe for concurrent use
+func For(n, threads int, f func(i int)) {
+	if threads < 1 {
+		threads = 1
+	}
+	if threads == 1 || n < 2 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+	indices := make(chan int, threads)
+	var wg sync.WaitGroup
+	for t := 0; t < threads; t++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
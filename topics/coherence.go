@@ -0,0 +1,192 @@
+package topics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// epsilon keeps the logarithms of the never co-occurring pairs finite
+const epsilon = 1e-12
+
+// TopWords returns the ids of the n most probable words of every topic in decreasing order
+func TopWords(logPhi [][]float64, n int) [][]int {
+	top := make([][]int, len(logPhi))
+	for i, row := range logPhi {
+		order := make([]int, len(row))
+		for w := range order {
+			order[w] = w
+		}
+		sort.SliceStable(order, func(a, b int) bool { return row[order[a]] > row[order[b]] })
+		if n < len(order) {
+			order = order[:n]
+		}
+		top[i] = order
+	}
+	return top
+}
+
+// Words returns the words as vocabulary entries, or as word ids without the vocabulary, separated by spaces
+func Words(words []int, vocab []string) string {
+	result := make([]string, len(words))
+	for i, w := range words {
+		if vocab != nil {
+			result[i] = vocab[w]
+		} else {
+			result[i] = strconv.Itoa(w)
+		}
+	}
+	return strings.Join(result, " ")
+}
+
+// cooccurrence keeps the numbers of the windows containing the words and the pairs of words
+type cooccurrence struct {
+	index   map[int]int
+	single  []float64
+	pair    [][]float64
+	windows float64
+}
+
+// countCooccurrence counts the sliding windows of the given size over the documents that contain
+// the words of interest, every document is a single window if the size is not positive
+func countCooccurrence(docs [][]int, words [][]int, size int) *cooccurrence {
+	c := &cooccurrence{index: make(map[int]int)}
+	for _, ws := range words {
+		for _, w := range ws {
+			if _, ok := c.index[w]; !ok {
+				c.index[w] = len(c.index)
+			}
+		}
+	}
+	n := len(c.index)
+	c.single = make([]float64, n)
+	c.pair = make([][]float64, n)
+	for i := range c.pair {
+		c.pair[i] = make([]float64, n)
+	}
+
+	seen := make([]bool, n)
+	present := make([]int, 0, n)
+	count := func(window []int) {
+		present = present[:0]
+		for _, w := range window {
+			if i, ok := c.index[w]; ok && !seen[i] {
+				seen[i] = true
+				present = append(present, i)
+			}
+		}
+		for a, i := range present {
+			c.single[i]++
+			for _, j := range present[a+1:] {
+				c.pair[i][j]++
+				c.pair[j][i]++
+			}
+			seen[i] = false
+		}
+		c.windows++
+	}
+	for _, doc := range docs {
+		if size <= 0 || len(doc) <= size {
+			count(doc)
+			continue
+		}
+		for start := 0; start+size <= len(doc); start++ {
+			count(doc[start : start+size])
+		}
+	}
+	return c
+}
+
+// npmi returns the normalized pointwise mutual information of the words
+func (c *cooccurrence) npmi(a, b int) float64 {
+	i, j := c.index[a], c.index[b]
+	if i == j {
+		return 1
+	}
+	if c.single[i] == 0 || c.single[j] == 0 {
+		// the limit for the words missing from the reference corpus
+		return -1
+	}
+	pij := c.pair[i][j]/c.windows + epsilon
+	pi := c.single[i] / c.windows
+	pj := c.single[j] / c.windows
+	return math.Log(pij/(pi*pj)) / -math.Log(pij)
+}
+
+// UMass returns the coherence of Mimno et al. (2011) of every topic averaged over the pairs of its
+// top words, log (D(w_i, w_j) + 1) / D(w_j) for the more probable w_j, from document co-occurrences,
+// every topic needs at least two top words
+func UMass(top [][]int, docs [][]int) []float64 {
+	c := countCooccurrence(docs, top, 0)
+	result := make([]float64, len(top))
+	for t, ws := range top {
+		sum, pairs := 0.0, 0
+		for i := 1; i < len(ws); i++ {
+			for j := 0; j < i; j++ {
+				wi, wj := c.index[ws[i]], c.index[ws[j]]
+				sum += math.Log((c.pair[wi][wj] + 1) / math.Max(c.single[wj], 1))
+				pairs++
+			}
+		}
+		result[t] = sum / float64(pairs)
+	}
+	return result
+}
+
+// NPMI returns the normalized pointwise mutual information of every topic averaged over the pairs
+// of its top words, estimated from sliding windows of the given size, every topic needs at least two top words
+func NPMI(top [][]int, docs [][]int, window int) []float64 {
+	c := countCooccurrence(docs, top, window)
+	result := make([]float64, len(top))
+	for t, ws := range top {
+		sum, pairs := 0.0, 0
+		for i := 1; i < len(ws); i++ {
+			for j := 0; j < i; j++ {
+				sum += c.npmi(ws[i], ws[j])
+				pairs++
+			}
+		}
+		result[t] = sum / float64(pairs)
+	}
+	return result
+}
+
+// CV returns the C_v coherence of Röder et al. (2015) of every topic: the average cosine similarity
+// of the NPMI context vector of each top word with the context vector of all top words, estimated
+// from sliding windows of the given size
+func CV(top [][]int, docs [][]int, window int) []float64 {
+	c := countCooccurrence(docs, top, window)
+	result := make([]float64, len(top))
+	for t, ws := range top {
+		n := len(ws)
+		vectors := make([][]float64, n)
+		all := make([]float64, n)
+		for i, wi := range ws {
+			vectors[i] = make([]float64, n)
+			for j, wj := range ws {
+				vectors[i][j] = c.npmi(wi, wj)
+				all[j] += vectors[i][j]
+			}
+		}
+		sum := 0.0
+		for _, v := range vectors {
+			sum += cosine(v, all)
+		}
+		result[t] = sum / float64(n)
+	}
+	return result
+}
+
+func cosine(x, y []float64) float64 {
+	dot, xx, yy := 0.0, 0.0, 0.0
+	for i := range x {
+		dot += x[i] * y[i]
+		xx += x[i] * x[i]
+		yy += y[i] * y[i]
+	}
+	if xx == 0 || yy == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(xx*yy)
+}
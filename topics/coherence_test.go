@@ -0,0 +1,69 @@
+package topics
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// docs is a toy corpus: words 0 and 1 co-occur twice, 0 and 2 once, 1 and 3 never
+var docs = [][]int{{0, 1}, {0, 1, 2}, {0}, {2, 3}}
+
+func near(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol
+}
+
+func TestTopWords(t *testing.T) {
+	logPhi := [][]float64{
+		{math.Log(0.1), math.Log(0.6), math.Log(0.3)},
+		{math.Log(0.5), math.Log(0.2), math.Log(0.3)},
+	}
+	if got, want := TopWords(logPhi, 2), [][]int{{1, 2}, {0, 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TopWords = %v, want %v", got, want)
+	}
+	if got := TopWords(logPhi, 5); len(got[0]) != 3 {
+		t.Errorf("TopWords beyond the vocabulary = %v", got)
+	}
+}
+
+func TestUMass(t *testing.T) {
+	// log (D(w_i, w_j) + 1) / D(w_j) with w_j the first, more probable word
+	got := UMass([][]int{{0, 1}, {0, 2}, {1, 3}}, docs)
+	want := []float64{math.Log(3.0 / 3.0), math.Log(2.0 / 3.0), math.Log(1.0 / 2.0)}
+	for i := range want {
+		if !near(got[i], want[i], 1e-12) {
+			t.Errorf("UMass[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNPMI(t *testing.T) {
+	got := NPMI([][]int{{0, 1}, {1, 3}}, docs, 0)
+	// p(0, 1) = 2/4, p(0) = 3/4, p(1) = 2/4
+	if want := math.Log(4.0/3.0) / math.Log(2); !near(got[0], want, 1e-9) {
+		t.Errorf("NPMI of co-occurring words = %v, want %v", got[0], want)
+	}
+	if !near(got[1], -1, 0.1) {
+		t.Errorf("NPMI of never co-occurring words = %v, want about -1", got[1])
+	}
+}
+
+func TestSlidingWindows(t *testing.T) {
+	c := countCooccurrence([][]int{{0, 1, 2}, {1}}, [][]int{{0, 1, 2}}, 2)
+	// the windows {0, 1}, {1, 2} and the short document {1}
+	if c.windows != 3 {
+		t.Errorf("%v windows, want 3", c.windows)
+	}
+	i0, i1, i2 := c.index[0], c.index[1], c.index[2]
+	if c.single[i1] != 3 || c.pair[i0][i1] != 1 || c.pair[i0][i2] != 0 {
+		t.Errorf("single = %v, pair = %v", c.single, c.pair)
+	}
+}
+
+func TestCV(t *testing.T) {
+	for i, cv := range CV([][]int{{0, 1, 2}, {1, 3}}, docs, 0) {
+		if cv < -1 || cv > 1 || math.IsNaN(cv) {
+			t.Errorf("C_v[%d] = %v out of [-1, 1]", i, cv)
+		}
+	}
+}
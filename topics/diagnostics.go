@@ -0,0 +1,207 @@
+package topics
+
+import (
+	"math"
+	"sort"
+
+	"bitbucket.org/sitfoxfly/ranklda/umath"
+)
+
+// Diversity returns the fraction of unique words among the top words of all topics
+func Diversity(top [][]int) float64 {
+	unique := make(map[int]bool)
+	total := 0
+	for _, ws := range top {
+		for _, w := range ws {
+			unique[w] = true
+		}
+		total += len(ws)
+	}
+	return float64(len(unique)) / float64(total)
+}
+
+// phi returns the topic-word distributions
+func phi(logPhi [][]float64) [][]float64 {
+	result := make([][]float64, len(logPhi))
+	for i, row := range logPhi {
+		result[i] = make([]float64, len(row))
+		for w, x := range row {
+			result[i][w] = math.Exp(x)
+		}
+	}
+	return result
+}
+
+// Exclusivity returns the exclusivity phi_k(w) / Σ_j phi_j(w) of every word in every topic
+func Exclusivity(logPhi [][]float64) [][]float64 {
+	p := phi(logPhi)
+	v := len(p[0])
+	sums := make([]float64, v)
+	for _, row := range p {
+		for w, x := range row {
+			sums[w] += x
+		}
+	}
+	for _, row := range p {
+		for w := range row {
+			row[w] /= sums[w]
+		}
+	}
+	return p
+}
+
+// ecdf returns the empirical cumulative distribution of every value among all values
+func ecdf(x []float64) []float64 {
+	ranks := umath.Ranks(x)
+	for i := range ranks {
+		ranks[i] /= float64(len(x))
+	}
+	return ranks
+}
+
+// FREX returns the mean FREX score of the top words of every topic, the weighted harmonic mean
+// of the within-topic quantiles of the exclusivity and of the frequency (Bischof and Airoldi, 2012),
+// weight is the weight of the exclusivity, together with the mean exclusivity of the top words
+func FREX(logPhi [][]float64, top [][]int, weight float64) (frex, exclusivity []float64) {
+	excl := Exclusivity(logPhi)
+	frex = make([]float64, len(logPhi))
+	exclusivity = make([]float64, len(logPhi))
+	for k, row := range logPhi {
+		exclQ := ecdf(excl[k])
+		freqQ := ecdf(row)
+		for _, w := range top[k] {
+			frex[k] += 1 / (weight/exclQ[w] + (1-weight)/freqQ[w])
+			exclusivity[k] += excl[k][w]
+		}
+		frex[k] /= float64(len(top[k]))
+		exclusivity[k] /= float64(len(top[k]))
+	}
+	return frex, exclusivity
+}
+
+// Hellinger returns the Hellinger distance of the distributions
+func Hellinger(p, q []float64) float64 {
+	sum := 0.0
+	for i := range p {
+		d := math.Sqrt(p[i]) - math.Sqrt(q[i])
+		sum += d * d
+	}
+	return math.Sqrt(sum / 2)
+}
+
+// JensenShannon returns the Jensen-Shannon distance of the distributions, the square root
+// of the divergence in bits, which lies in [0, 1]
+func JensenShannon(p, q []float64) float64 {
+	div := 0.0
+	for i := range p {
+		m := (p[i] + q[i]) / 2
+		if p[i] > 0 {
+			div += p[i] * math.Log2(p[i]/m)
+		}
+		if q[i] > 0 {
+			div += q[i] * math.Log2(q[i]/m)
+		}
+	}
+	return math.Sqrt(math.Max(div/2, 0))
+}
+
+// Distances returns the pairwise Hellinger and Jensen-Shannon distances of the topics
+func Distances(logPhi [][]float64) (hellinger, js [][]float64) {
+	p := phi(logPhi)
+	k := len(p)
+	hellinger = make([][]float64, k)
+	js = make([][]float64, k)
+	for i := 0; i < k; i++ {
+		hellinger[i] = make([]float64, k)
+		js[i] = make([]float64, k)
+	}
+	for i := 0; i < k; i++ {
+		for j := i + 1; j < k; j++ {
+			hellinger[i][j] = Hellinger(p[i], p[j])
+			hellinger[j][i] = hellinger[i][j]
+			js[i][j] = JensenShannon(p[i], p[j])
+			js[j][i] = js[i][j]
+		}
+	}
+	return hellinger, js
+}
+
+// Prevalence returns the share of the tokens assigned to every topic and the average proportion
+// of every topic over the non-empty documents
+func Prevalence(z [][]int, k int) (tokens, docs []float64) {
+	tokens = make([]float64, k)
+	docs = make([]float64, k)
+	total, nonEmpty := 0, 0
+	for _, zi := range z {
+		if len(zi) == 0 {
+			continue
+		}
+		for _, t := range zi {
+			tokens[t]++
+			docs[t] += 1 / float64(len(zi))
+		}
+		total += len(zi)
+		nonEmpty++
+	}
+	for t := 0; t < k; t++ {
+		tokens[t] /= float64(total)
+		docs[t] /= float64(nonEmpty)
+	}
+	return tokens, docs
+}
+
+// Coverage returns the fraction of the documents in which every topic takes more than minShare of the tokens
+func Coverage(z [][]int, k int, minShare float64) []float64 {
+	coverage := make([]float64, k)
+	counts := make([]int, k)
+	for _, zi := range z {
+		for t := range counts {
+			counts[t] = 0
+		}
+		for _, t := range zi {
+			counts[t]++
+		}
+		for t, c := range counts {
+			if c > 0 && float64(c)/float64(len(zi)) > minShare {
+				coverage[t]++
+			}
+		}
+	}
+	for t := range coverage {
+		coverage[t] /= float64(len(z))
+	}
+	return coverage
+}
+
+// Background returns the corpus word distribution, the mixture of the topics weighted by their token shares
+func Background(logPhi [][]float64, tokens []float64) []float64 {
+	p := phi(logPhi)
+	result := make([]float64, len(p[0]))
+	for k, row := range p {
+		for w, x := range row {
+			result[w] += tokens[k] * x
+		}
+	}
+	return result
+}
+
+// Pair is a pair of topics with their distances
+type Pair struct {
+	A, B      int
+	Hellinger float64
+	JS        float64
+}
+
+// Duplicates returns the pairs of topics closer than the Jensen-Shannon distance threshold, closest first
+func Duplicates(hellinger, js [][]float64, threshold float64) []Pair {
+	result := make([]Pair, 0)
+	for i := range js {
+		for j := i + 1; j < len(js); j++ {
+			if js[i][j] < threshold {
+				result = append(result, Pair{i, j, hellinger[i][j], js[i][j]})
+			}
+		}
+	}
+	sort.SliceStable(result, func(a, b int) bool { return result[a].JS < result[b].JS })
+	return result
+}
@@ -0,0 +1,60 @@
+package topics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistances(t *testing.T) {
+	for _, c := range []struct {
+		p, q          []float64
+		hellinger, js float64
+	}{
+		{[]float64{0.3, 0.7}, []float64{0.3, 0.7}, 0, 0},
+		{[]float64{1, 0}, []float64{0, 1}, 1, 1},
+		{[]float64{0.5, 0.5}, []float64{1, 0}, 0.541196100146197, 0.5579230452841438},
+	} {
+		if h := Hellinger(c.p, c.q); !near(h, c.hellinger, 1e-12) {
+			t.Errorf("Hellinger(%v, %v) = %v, want %v", c.p, c.q, h, c.hellinger)
+		}
+		if js := JensenShannon(c.p, c.q); !near(js, c.js, 1e-12) {
+			t.Errorf("JensenShannon(%v, %v) = %v, want %v", c.p, c.q, js, c.js)
+		}
+		if Hellinger(c.p, c.q) != Hellinger(c.q, c.p) || JensenShannon(c.p, c.q) != JensenShannon(c.q, c.p) {
+			t.Errorf("distances of %v and %v are not symmetric", c.p, c.q)
+		}
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	logPhi := [][]float64{
+		{math.Log(0.5), math.Log(0.5), math.Inf(-1)},
+		{math.Log(0.49), math.Log(0.51), math.Inf(-1)},
+		{math.Inf(-1), math.Log(0.1), math.Log(0.9)},
+	}
+	hellinger, js := Distances(logPhi)
+	if hellinger[0][0] != 0 || js[1][1] != 0 || js[0][2] != js[2][0] {
+		t.Errorf("distance matrices are not symmetric with a zero diagonal: %v %v", hellinger, js)
+	}
+	pairs := Duplicates(hellinger, js, 0.1)
+	if len(pairs) != 1 || pairs[0].A != 0 || pairs[0].B != 1 {
+		t.Errorf("Duplicates = %v, want topics 0 and 1", pairs)
+	}
+}
+
+func TestDiversity(t *testing.T) {
+	if d := Diversity([][]int{{0, 1}, {1, 2}}); d != 0.75 {
+		t.Errorf("Diversity = %v, want 0.75", d)
+	}
+}
+
+func TestPrevalence(t *testing.T) {
+	tokens, docs := Prevalence([][]int{{0, 0, 1, 1}, {0, 0}, {}}, 2)
+	if tokens[0] != 4.0/6.0 || tokens[1] != 2.0/6.0 {
+		t.Errorf("token shares = %v", tokens)
+	}
+	// the empty document is skipped
+	if docs[0] != 0.75 || docs[1] != 0.25 {
+		t.Errorf("document proportions = %v", docs)
+	}
+}
@@ -0,0 +1,186 @@
+package umath
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+// Ranks returns the 1-based ranks of x in ascending order, tied values get their average rank
+func Ranks(x []float64) []float64 {
+	n := len(x)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return x[order[a]] < x[order[b]] })
+	ranks := make([]float64, n)
+	for i := 0; i < n; {
+		j := i + 1
+		for j < n && x[order[j]] == x[order[i]] {
+			j++
+		}
+		rank := float64(i+j+1) / 2.0
+		for ; i < j; i++ {
+			ranks[order[i]] = rank
+		}
+	}
+	return ranks
+}
+
+// Pearson returns the linear correlation of x and y, NaN if either is constant
+func Pearson(x, y []float64) float64 {
+	n := len(x)
+	if n != len(y) {
+		log.Panic("ERROR: vector length mismatch")
+	}
+	mx, my := 0.0, 0.0
+	for i := 0; i < n; i++ {
+		mx += x[i]
+		my += y[i]
+	}
+	mx /= float64(n)
+	my /= float64(n)
+	sxy, sxx, syy := 0.0, 0.0, 0.0
+	for i := 0; i < n; i++ {
+		dx, dy := x[i]-mx, y[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// Spearman returns the rank correlation of x and y with ties handled by average ranks
+func Spearman(x, y []float64) float64 {
+	return Pearson(Ranks(x), Ranks(y))
+}
+
+// KendallTau returns the tau-b rank correlation of x and y, which accounts for ties in either,
+// by the O(n log n) algorithm of Knight (1966): the pairs are sorted by x and then by y, and the
+// discordant pairs are counted as the exchanges of a merge sort by y
+func KendallTau(x, y []float64) float64 {
+	n := len(x)
+	if n != len(y) {
+		log.Panic("ERROR: vector length mismatch")
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		return x[i] < x[j] || x[i] == x[j] && y[i] < y[j]
+	})
+
+	// the pairs tied in x and the pairs tied in both
+	var tiesX, tiesXY int64
+	for i := 0; i < n; {
+		j := i + 1
+		for j < n && x[order[j]] == x[order[i]] {
+			j++
+		}
+		tiesX += pairs(j - i)
+		for a := i; a < j; {
+			b := a + 1
+			for b < j && y[order[b]] == y[order[a]] {
+				b++
+			}
+			tiesXY += pairs(b - a)
+			a = b
+		}
+		i = j
+	}
+
+	values := make([]float64, n)
+	for i, j := range order {
+		values[i] = y[j]
+	}
+	discordant := mergeCount(values, make([]float64, n))
+
+	// values are sorted by y now
+	var tiesY int64
+	for i := 0; i < n; {
+		j := i + 1
+		for j < n && values[j] == values[i] {
+			j++
+		}
+		tiesY += pairs(j - i)
+		i = j
+	}
+
+	total := pairs(n)
+	numerator := float64(total - tiesX - tiesY + tiesXY - 2*discordant)
+	return numerator / math.Sqrt(float64(total-tiesX)*float64(total-tiesY))
+}
+
+func pairs(n int) int64 {
+	return int64(n) * int64(n-1) / 2
+}
+
+// mergeCount sorts x in place by merge sort using the buffer and returns the number of pairs out of order
+func mergeCount(x, buf []float64) int64 {
+	n := len(x)
+	if n < 2 {
+		return 0
+	}
+	mid := n / 2
+	count := mergeCount(x[:mid], buf[:mid]) + mergeCount(x[mid:], buf[mid:])
+	i, j, k := 0, mid, 0
+	for i < mid && j < n {
+		if x[j] < x[i] {
+			// x[j] precedes all the remaining values of the left half
+			buf[k] = x[j]
+			count += int64(mid - i)
+			j++
+		} else {
+			buf[k] = x[i]
+			i++
+		}
+		k++
+	}
+	k += copy(buf[k:], x[i:mid])
+	copy(buf[k:], x[j:])
+	copy(x, buf)
+	return count
+}
+
+// AUC returns the probability that a positive value exceeds a negative one, ties count half
+func AUC(pos, neg []float64) float64 {
+	ranks := Ranks(append(append([]float64(nil), pos...), neg...))
+	sum := 0.0
+	for i := range pos {
+		sum += ranks[i]
+	}
+	np, nn := float64(len(pos)), float64(len(neg))
+	return (sum - np*(np+1)/2.0) / (np * nn)
+}
+
+// NDCG returns the normalized discounted cumulative gain at k of the ranking by scores
+// against the graded relevance, the gain of a grade g is 2^g - 1, it is 0 if no document has a gain
+func NDCG(scores, relevance []float64, k int) float64 {
+	n := len(scores)
+	if n != len(relevance) {
+		log.Panic("ERROR: vector length mismatch")
+	}
+	dcg := func(order []int) float64 {
+		result := 0.0
+		for i := 0; i < k && i < n; i++ {
+			result += (math.Pow(2, relevance[order[i]]) - 1) / math.Log2(float64(i+2))
+		}
+		return result
+	}
+	byScore := make([]int, n)
+	byRelevance := make([]int, n)
+	for i := 0; i < n; i++ {
+		byScore[i] = i
+		byRelevance[i] = i
+	}
+	sort.SliceStable(byScore, func(a, b int) bool { return scores[byScore[a]] > scores[byScore[b]] })
+	sort.SliceStable(byRelevance, func(a, b int) bool { return relevance[byRelevance[a]] > relevance[byRelevance[b]] })
+	ideal := dcg(byRelevance)
+	if ideal == 0 {
+		return 0
+	}
+	return dcg(byScore) / ideal
+}
@@ -0,0 +1,82 @@
+package umath
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// kendallTauPairs is the quadratic tau-b over all pairs
+func kendallTauPairs(x, y []float64) float64 {
+	var concordant, discordant, tiesX, tiesY float64
+	for i := range x {
+		for j := i + 1; j < len(x); j++ {
+			dx, dy := x[i]-x[j], y[i]-y[j]
+			switch {
+			case dx == 0 && dy == 0:
+			case dx == 0:
+				tiesX++
+			case dy == 0:
+				tiesY++
+			case dx*dy > 0:
+				concordant++
+			default:
+				discordant++
+			}
+		}
+	}
+	return (concordant - discordant) / math.Sqrt((concordant+discordant+tiesX)*(concordant+discordant+tiesY))
+}
+
+func TestKendallTau(t *testing.T) {
+	for _, c := range []struct {
+		x, y []float64
+		tau  float64
+	}{
+		{[]float64{1, 2, 3, 4}, []float64{10, 20, 30, 40}, 1},
+		{[]float64{1, 2, 3, 4}, []float64{4, 3, 2, 1}, -1},
+		{[]float64{1, 2, 3}, []float64{1, 3, 2}, 1.0 / 3.0},
+		// one tie in y: 2 concordant, 0 discordant, 1 pair tied in y
+		{[]float64{1, 2, 3}, []float64{1, 2, 2}, 2 / math.Sqrt(6)},
+	} {
+		if tau := KendallTau(c.x, c.y); math.Abs(tau-c.tau) > 1e-12 {
+			t.Errorf("KendallTau(%v, %v) = %v, want %v", c.x, c.y, tau, c.tau)
+		}
+	}
+	if tau := KendallTau([]float64{1, 1, 1}, []float64{1, 2, 3}); !math.IsNaN(tau) {
+		t.Errorf("KendallTau of a constant = %v, want NaN", tau)
+	}
+}
+
+func TestKendallTauTies(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for iter := 0; iter < 200; iter++ {
+		n := 2 + rng.Intn(40)
+		x := make([]float64, n)
+		y := make([]float64, n)
+		// few distinct values, so that ties in x, in y and in both are frequent
+		for i := range x {
+			x[i] = float64(rng.Intn(5))
+			y[i] = float64(rng.Intn(4))
+		}
+		got, want := KendallTau(x, y), kendallTauPairs(x, y)
+		if math.IsNaN(got) != math.IsNaN(want) || math.Abs(got-want) > 1e-12 {
+			t.Fatalf("KendallTau(%v, %v) = %v, want %v", x, y, got, want)
+		}
+	}
+}
+
+func TestNDCG(t *testing.T) {
+	relevance := []float64{0, 1, 2}
+	if ndcg := NDCG([]float64{0.1, 0.5, 0.9}, relevance, 3); math.Abs(ndcg-1) > 1e-12 {
+		t.Errorf("NDCG of the ideal ranking = %v, want 1", ndcg)
+	}
+	// gains 1 and 3 at the positions 1 and 2 against 3 and 1
+	want := (1 + 3/math.Log2(3)) / (3 + 1/math.Log2(3))
+	if ndcg := NDCG([]float64{0.1, 0.9, 0.5}, relevance, 3); math.Abs(ndcg-want) > 1e-12 {
+		t.Errorf("NDCG = %v, want %v", ndcg, want)
+	}
+	if ndcg := NDCG([]float64{0.1, 0.9, 0.5}, []float64{0, 0, 0}, 3); ndcg != 0 {
+		t.Errorf("NDCG without relevant documents = %v, want 0", ndcg)
+	}
+}
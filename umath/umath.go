@@ -68,8 +68,19 @@ func Anxmany(a []float64, x, y []int, nx, ny int) float64 {
 	return result
 }
 
-func SampleFromLogDist(dist []float64) int {
-	p := rand.Float64()
+// NewRand returns a random generator for the given stream derived from the seed,
+// so that every stream is reproducible independently of the others
+func NewRand(seed int64, stream int) *rand.Rand {
+	x := uint64(seed) ^ (uint64(stream)+1)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return rand.New(rand.NewSource(int64(x)))
+}
+
+// SampleFromLogDist samples an index from the log-probability distribution
+func SampleFromLogDist(dist []float64, rng *rand.Rand) int {
+	p := rng.Float64()
 	cdf := 0.0
 	for i, logProb := range dist {
 		cdf += math.Exp(logProb)
@@ -79,3 +90,38 @@ func SampleFromLogDist(dist []float64) int {
 	}
 	return len(dist) - 1
 }
+
+// SampleGamma samples from the Gamma distribution with the shape a and the unit scale
+// by the method of Marsaglia and Tsang
+func SampleGamma(a float64, rng *rand.Rand) float64 {
+	if a < 1 {
+		// boosting the shape, Gamma(a) = Gamma(a+1) * U^(1/a)
+		return SampleGamma(a+1, rng) * math.Pow(rng.Float64(), 1.0/a)
+	}
+	d := a - 1.0/3.0
+	c := 1.0 / math.Sqrt(9.0*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1.0 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+// SampleDirichlet fills theta with a sample from the Dirichlet distribution with the parameters alpha
+func SampleDirichlet(theta, alpha []float64, rng *rand.Rand) {
+	sum := 0.0
+	for i, a := range alpha {
+		theta[i] = SampleGamma(a, rng)
+		sum += theta[i]
+	}
+	for i := range theta {
+		theta[i] /= sum
+	}
+}